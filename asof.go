@@ -0,0 +1,55 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//RevisionAsOf returns the revision from the history of an item (oldest first)
+//as known at knownAt and effective at validAt, see ITable.GetItemAsOf()
+//it returns nil if there is no such revision
+func RevisionAsOf(revs []IItem, knownAt time.Time, validAt time.Time) IItem {
+	for n := len(revs) - 1; n >= 0; n-- {
+		r := revs[n].Rev()
+		if r.Timestamp().After(knownAt) || !r.ValidAt(validAt) {
+			continue
+		}
+		return revs[n]
+	}
+	return nil
+}
+
+func (t *table) GetItemAsOf(uid string, knownAt time.Time, validAt time.Time) (IItem, error) {
+	return t.GetItemAsOfContext(context.Background(), uid, knownAt, validAt)
+}
+
+func (t *table) GetItemAsOfContext(ctx context.Context, uid string, knownAt time.Time, validAt time.Time) (IItem, error) {
+	//use the table as registered in the db, which wraps this table
+	self := t.db.GetTable(t.name)
+	if self == nil {
+		return nil, fmt.Errorf("db(%s).table(%s) not found", t.db.Name(), t.name)
+	}
+	revs, err := self.HistoryContext(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	item := RevisionAsOf(revs, knownAt, validAt)
+	if item == nil {
+		return nil, errors.Wrapf(ErrNotFound, "%s.uid=%s as of %v valid at %v", t.name, uid, knownAt, validAt)
+	}
+	if item.Rev().Deleted() {
+		return nil, errors.Wrapf(ErrDeleted, "%s.uid=%s as of %v valid at %v", t.name, uid, knownAt, validAt)
+	}
+	return item, nil
+} //table.GetItemAsOfContext()
+
+func (t *table) ItemsAsOf(knownAt time.Time, validAt time.Time) (map[string]IItem, error) {
+	return t.ItemsAsOfContext(context.Background(), knownAt, validAt)
+}
+
+func (t *table) ItemsAsOfContext(ctx context.Context, knownAt time.Time, validAt time.Time) (map[string]IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).ItemsAsOf() not implemented", t.db.Name(), t.name)
+}
@@ -0,0 +1,28 @@
+package items
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//BatchError is returned when some entries of a batch write failed
+//the batch is written as one unit, so none of the entries were written
+type BatchError struct {
+	Table string
+	//Errs has the error of each entry that failed, by position in the batch
+	Errs map[int]error
+}
+
+func (e *BatchError) Error() string {
+	list := make([]int, 0, len(e.Errs))
+	for n := range e.Errs {
+		list = append(list, n)
+	}
+	sort.Ints(list)
+	msgs := make([]string, 0, len(list))
+	for _, n := range list {
+		msgs = append(msgs, fmt.Sprintf("[%d]: %v", n, e.Errs[n]))
+	}
+	return fmt.Sprintf("%s batch failed on %d entries: %s", e.Table, len(e.Errs), strings.Join(msgs, "; "))
+}
@@ -1,9 +1,11 @@
 package items
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
 	//	"github.com/jansemmelink/log"
 	"github.com/pkg/errors"
@@ -14,18 +16,33 @@ type IDb interface {
 	Name() string
 
 	Table(name string, tmplStruct IData) (ITable, error)
+	TableContext(ctx context.Context, name string, tmplStruct IData) (ITable, error)
 	MustTable(name string, tmplStruct IData) ITable
 	RemTable(t ITable)
+	SetTable(t ITable)
 	GetTable(name string) ITable
 	Tables() map[string]ITable
+
+	//Now is the timestamp for a new revision, see WithClock()
+	Now() time.Time
+	//NewUID returns the uid for a new item, see WithUIDs()
+	NewUID() string
 }
 
 //New database should be called by implementation, not by users
-func New(name string) IDb {
-	return &Database{
+//implementations pass on the options of their users
+func New(name string, options ...Option) IDb {
+	d := &Database{
 		name:   name,
 		tables: make(map[string]ITable),
+		refs:   &refs{},
+		clock:  time.Now,
+		uids:   UUIDv4(),
+	}
+	for _, option := range options {
+		option(d)
 	}
+	return d
 }
 
 //Database implements IDb and can be embedded into user database types
@@ -34,6 +51,9 @@ type Database struct {
 	mutex  sync.Mutex
 	name   string
 	tables map[string]ITable
+	refs   *refs
+	clock  Clock
+	uids   UIDGenerator
 }
 
 //Name ...
@@ -43,6 +63,14 @@ func (d *Database) Name() string {
 
 //Table ...
 func (d *Database) Table(name string, tmplStruct IData) (ITable, error) {
+	return d.TableContext(context.Background(), name, tmplStruct)
+}
+
+//TableContext ...
+func (d *Database) TableContext(ctx context.Context, name string, tmplStruct IData) (ITable, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if err := validateIdentifier(name); err != nil {
 		return nil, errors.Wrapf(err, "Database(%s).table(%s) invalid name", d.name, name)
 	}
@@ -63,6 +91,9 @@ func (d *Database) Table(name string, tmplStruct IData) (ITable, error) {
 		name:       name,
 		structType: reflect.TypeOf(tmplStruct),
 		schema:     schema,
+		feed:       NewFeed(0),
+		hooks:      &Hooks{},
+		refs:       d.refs,
 	}
 	d.tables[t.Name()] = t
 	return t, nil
@@ -84,6 +115,17 @@ func (d *Database) RemTable(t ITable) {
 	}
 }
 
+//SetTable replaces the table registered with the same name
+//it is used by implementations that wrap the default table
+//so that GetTable() and Tables() return the wrapped table
+func (d *Database) SetTable(t ITable) {
+	if d != nil && t != nil {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		d.tables[t.Name()] = t
+	}
+}
+
 //GetTable ...
 func (d *Database) GetTable(name string) ITable {
 	d.mutex.Lock()
@@ -94,5 +136,21 @@ func (d *Database) GetTable(name string) ITable {
 
 //Tables ...
 func (d *Database) Tables() map[string]ITable {
-	return d.tables
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	tables := make(map[string]ITable, len(d.tables))
+	for name, t := range d.tables {
+		tables[name] = t
+	}
+	return tables
+}
+
+//Now ...
+func (d *Database) Now() time.Time {
+	return d.clock()
+}
+
+//NewUID ...
+func (d *Database) NewUID() string {
+	return d.uids()
 }
@@ -0,0 +1,135 @@
+package items
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+//FieldDiff is a field that changed between two revisions
+type FieldDiff struct {
+	//Path of the field, e.g. "Name", "Address.City", "Tags[2]" or "Attrs[colour]"
+	Path string
+	//Old value, nil if the field was added, e.g. a new slice element or map key
+	Old interface{}
+	//New value, nil if the field was removed
+	New interface{}
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: %v -> %v", d.Path, d.Old, d.New)
+}
+
+//Diff lists the fields that changed from revision a to revision b, in field order
+//it walks nested structs, slices and maps, and ignores unexported fields like StructFields()
+//a nil item compares as the zero value of the other item's data, e.g. to diff the first revision
+func Diff(a, b IItem) ([]FieldDiff, error) {
+	if a == nil && b == nil {
+		return []FieldDiff{}, nil
+	}
+	var aData, bData IData
+	if a != nil {
+		aData = a.Data()
+	}
+	if b != nil {
+		bData = b.Data()
+	}
+	return DiffData(aData, bData)
+}
+
+//DiffData lists the fields that changed from data a to data b, see Diff()
+func DiffData(a, b IData) ([]FieldDiff, error) {
+	list := make([]FieldDiff, 0)
+	switch {
+	case a == nil && b == nil:
+		return list, nil
+	case a == nil:
+		a = reflect.Zero(reflect.TypeOf(b)).Interface().(IData)
+	case b == nil:
+		b = reflect.Zero(reflect.TypeOf(a)).Interface().(IData)
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return nil, fmt.Errorf("cannot diff %T and %T", a, b)
+	}
+	diffValue("", reflect.ValueOf(a), reflect.ValueOf(b), &list)
+	return list, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+//diffValue appends the differences between a and b of the same type to the list
+func diffValue(path string, a, b reflect.Value, list *[]FieldDiff) {
+	switch a.Kind() {
+	case reflect.Struct:
+		if a.Type() == timeType {
+			if !a.Interface().(time.Time).Equal(b.Interface().(time.Time)) {
+				*list = append(*list, FieldDiff{Path: path, Old: a.Interface(), New: b.Interface()})
+			}
+			return
+		}
+		for fieldIndex := 0; fieldIndex < a.NumField(); fieldIndex++ {
+			structField := a.Type().Field(fieldIndex)
+			if structField.PkgPath != "" {
+				continue //unexported
+			}
+			fieldPath := structField.Name
+			if path != "" {
+				fieldPath = path + "." + structField.Name
+			}
+			diffValue(fieldPath, a.Field(fieldIndex), b.Field(fieldIndex), list)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < a.Len() || i < b.Len(); i++ {
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= a.Len():
+				*list = append(*list, FieldDiff{Path: elemPath, New: b.Index(i).Interface()})
+			case i >= b.Len():
+				*list = append(*list, FieldDiff{Path: elemPath, Old: a.Index(i).Interface()})
+			default:
+				diffValue(elemPath, a.Index(i), b.Index(i), list)
+			}
+		}
+
+	case reflect.Map:
+		//keys of both maps in a stable order
+		keys := make(map[string]reflect.Value)
+		for _, key := range append(a.MapKeys(), b.MapKeys()...) {
+			keys[fmt.Sprintf("%v", key.Interface())] = key
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			elemPath := fmt.Sprintf("%s[%s]", path, name)
+			aElem := a.MapIndex(keys[name])
+			bElem := b.MapIndex(keys[name])
+			switch {
+			case !aElem.IsValid():
+				*list = append(*list, FieldDiff{Path: elemPath, New: bElem.Interface()})
+			case !bElem.IsValid():
+				*list = append(*list, FieldDiff{Path: elemPath, Old: aElem.Interface()})
+			default:
+				diffValue(elemPath, aElem, bElem, list)
+			}
+		}
+
+	case reflect.Ptr, reflect.Interface:
+		switch {
+		case a.IsNil() && b.IsNil():
+		case a.IsNil() || b.IsNil() || a.Elem().Type() != b.Elem().Type():
+			*list = append(*list, FieldDiff{Path: path, Old: a.Interface(), New: b.Interface()})
+		default:
+			diffValue(path, a.Elem(), b.Elem(), list)
+		}
+
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*list = append(*list, FieldDiff{Path: path, Old: a.Interface(), New: b.Interface()})
+		}
+	}
+} //diffValue()
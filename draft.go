@@ -0,0 +1,120 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//ErrSelfApproval is returned when the actor that proposed a draft tries to approve it
+//use errors.Is(err, ErrSelfApproval) to check for it
+var ErrSelfApproval = errors.New("cannot approve own draft")
+
+//ErrNoActor is returned when a draft is proposed or approved without an actor in the context, see WithActor()
+//use errors.Is(err, ErrNoActor) to check for it
+var ErrNoActor = errors.New("actor required")
+
+//ApprovedByAnnotation is the annotation of a revision written from an approved draft,
+//with the actor that approved it, while the revision has the actor that proposed it
+const ApprovedByAnnotation = "approvedBy"
+
+//Draft is a proposed next revision of an item that is written only when it is approved, see ITable.Propose()
+type Draft struct {
+	ID  int
+	UID string
+	NID int
+	//RevNr is the revision nr it is written as, so the item must still be at RevNr-1 when it is approved
+	RevNr int
+	Data  IData
+	//Info is written with the revision, from the context in which it was proposed, see WithActor() etc.
+	Info RevInfo
+	//Timestamp when it was proposed
+	Timestamp time.Time
+}
+
+//NewDraft checks the next revision of an item from NextItem() to propose
+//and returns it as a draft with the revision info in the context
+func NewDraft(ctx context.Context, t ITable, upd IItem) (Draft, error) {
+	if upd == nil {
+		return Draft{}, fmt.Errorf("%s.Propose(nil)", t.Name())
+	}
+	if RevInfoFrom(ctx).Actor == "" {
+		return Draft{}, errors.Wrapf(ErrNoActor, "%s.Propose(%s)", t.Name(), upd.UID())
+	}
+	cur, err := checkNext(ctx, t, upd, "Propose")
+	if err != nil {
+		return Draft{}, err
+	}
+	return Draft{
+		UID:       cur.UID(),
+		NID:       cur.NID(),
+		RevNr:     upd.Rev().Nr(),
+		Data:      upd.Data(),
+		Info:      RevInfoFrom(ctx),
+		Timestamp: t.Db().Now(),
+	}, nil
+}
+
+//Approve writes the draft to the table like UpdItem(), as approved by the actor in the context
+//table implementations remove the draft before calling it, and keep it when it fails
+func (d Draft) Approve(ctx context.Context, t ITable) (IItem, error) {
+	ctx, upd, err := d.Next(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	return t.UpdItemContext(ctx, upd)
+}
+
+//Next returns the draft as the next revision of the item, as approved by the actor in ctx,
+//with a context that has the revision info of the draft, to write like Approve()
+//it fails when there is no actor in ctx or when it is the actor that proposed the draft
+func (d Draft) Next(ctx context.Context, t ITable) (context.Context, IItem, error) {
+	approver := RevInfoFrom(ctx).Actor
+	if approver == "" {
+		return nil, nil, errors.Wrapf(ErrNoActor, "%s.draft=%d", t.Name(), d.ID)
+	}
+	if approver == d.Info.Actor {
+		return nil, nil, errors.Wrapf(ErrSelfApproval, "%s.draft=%d by %s", t.Name(), d.ID, approver)
+	}
+	info := d.Info.copy()
+	if info.Annotations == nil {
+		info.Annotations = make(map[string]string)
+	}
+	info.Annotations[ApprovedByAnnotation] = approver
+	upd := NewItem(t, d.NID, d.UID, NewRev(d.RevNr, t.Db().Now(), false, info), d.Data)
+	return context.WithValue(ctx, revInfoKey{}, info), upd, nil
+}
+
+func (t *table) Propose(upd IItem) (Draft, error) {
+	return t.ProposeContext(context.Background(), upd)
+}
+
+func (t *table) ProposeContext(ctx context.Context, upd IItem) (Draft, error) {
+	return Draft{}, fmt.Errorf("db(%s).table(%s).Propose() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) Drafts() ([]Draft, error) {
+	return t.DraftsContext(context.Background())
+}
+
+func (t *table) DraftsContext(ctx context.Context) ([]Draft, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).Drafts() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) Approve(id int) (IItem, error) {
+	return t.ApproveContext(context.Background(), id)
+}
+
+func (t *table) ApproveContext(ctx context.Context, id int) (IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).Approve() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) Reject(id int) error {
+	return t.RejectContext(context.Background(), id)
+}
+
+func (t *table) RejectContext(ctx context.Context, id int) error {
+	return fmt.Errorf("db(%s).table(%s).Reject() not implemented", t.db.Name(), t.name)
+}
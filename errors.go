@@ -0,0 +1,78 @@
+package items
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+//ErrNotFound is returned for an item that does not exist in the table
+//use errors.Is(err, ErrNotFound) to check for it
+var ErrNotFound = errors.New("not found")
+
+//ErrDeleted is returned for an item that existed but was deleted
+//use errors.Is(err, ErrDeleted) to check for it
+var ErrDeleted = errors.New("deleted")
+
+//ErrRevisionConflict is returned when writing the next revision of an item
+//that is no longer at the revision the write was based on,
+//i.e. someone else wrote the item in the meantime
+//get the item again to write the next revision from its latest revision
+type ErrRevisionConflict struct {
+	Table string
+	UID   string
+	//Expected is the revision nr that the write was based on
+	Expected int
+	//Actual is the latest revision nr of the item in the table
+	Actual int
+}
+
+func (e *ErrRevisionConflict) Error() string {
+	return fmt.Sprintf("%s.%s revision conflict: expected rev %d but latest is rev %d", e.Table, e.UID, e.Expected, e.Actual)
+}
+
+//ErrDuplicateKey is returned when writing an item with a key that is used by another item in a unique index
+type ErrDuplicateKey struct {
+	Table string
+	Index string
+	Key   string
+}
+
+func (e *ErrDuplicateKey) Error() string {
+	return fmt.Sprintf("%s.%s duplicate key %s", e.Table, e.Index, e.Key)
+}
+
+//ErrInvalidData is returned when writing item data that fails IData.Validate()
+//it wraps the error returned by Validate()
+type ErrInvalidData struct {
+	Table string
+	Err   error
+}
+
+func (e *ErrInvalidData) Error() string {
+	return fmt.Sprintf("invalid %s data: %v", e.Table, e.Err)
+}
+
+//Unwrap returns the validation error
+func (e *ErrInvalidData) Unwrap() error {
+	return e.Err
+}
+
+//ErrRetriesExhausted is returned by ITable.Modify() when the item was written by someone else
+//during each of the attempts to modify it, or by ITable.Upsert() with the index key instead of a uid
+//it wraps the last ErrRevisionConflict (or the reason Upsert() found the item with the key again)
+type ErrRetriesExhausted struct {
+	Table    string
+	UID      string
+	Attempts int
+	Err      error
+}
+
+func (e *ErrRetriesExhausted) Error() string {
+	return fmt.Sprintf("%s.%s not modified after %d attempts: %v", e.Table, e.UID, e.Attempts, e.Err)
+}
+
+//Unwrap returns the last revision conflict
+func (e *ErrRetriesExhausted) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,207 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+//ChangeType of a table write
+type ChangeType int
+
+//Types of changes
+const (
+	Added ChangeType = iota + 1
+	Updated
+	Deleted
+	//Purged items have no revisions anymore, see ITable.Purge()
+	Purged
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case Added:
+		return "added"
+	case Updated:
+		return "updated"
+	case Deleted:
+		return "deleted"
+	case Purged:
+		return "purged"
+	}
+	return fmt.Sprintf("ChangeType(%d)", int(c))
+}
+
+//Change describes one successful write to a table
+type Change struct {
+	//Seq is the position of the change in the table's feed
+	//use it to resume watching after the last change that was processed
+	Seq    uint64
+	Type   ChangeType
+	UID    string
+	OldRev int //0 when added
+	NewRev int
+	//Item is the new revision, or the deleted revision with the last data, or nil when purged
+	Item IItem
+}
+
+//Feed publishes table changes to watchers
+//Tables keep a backlog of recent changes so that a watcher can resume
+//from the last change it processed, e.g. after being dropped for being too slow
+type Feed struct {
+	mutex sync.Mutex
+	seq   uint64
+	//backlog is a ring of the last size changes, of which first is the oldest when it is full
+	backlog []Change
+	first   int
+	size    int
+	buffer  int
+	//subs are the watcher channels, each with a channel that is closed when it is dropped
+	subs map[chan Change]chan struct{}
+}
+
+const (
+	defaultFeedBacklog = 1000
+	defaultWatchBuffer = 100
+)
+
+//NewFeed with a backlog of the last n changes
+func NewFeed(n int) *Feed {
+	if n < 1 {
+		n = defaultFeedBacklog
+	}
+	return &Feed{
+		backlog: make([]Change, 0, n),
+		size:    n,
+		buffer:  defaultWatchBuffer,
+		subs:    make(map[chan Change]chan struct{}),
+	}
+}
+
+//Seq is the position of the last published change
+func (f *Feed) Seq() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.seq
+}
+
+//Publish a change to all watchers
+//old is nil when added, new is the revision written
+//it must be called by table implementations in the order of the writes
+func (f *Feed) Publish(changeType ChangeType, old IItem, new IItem) {
+	if f == nil || new == nil {
+		return
+	}
+	c := Change{
+		Type:   changeType,
+		UID:    new.UID(),
+		NewRev: new.Rev().Nr(),
+		Item:   new,
+	}
+	if old != nil {
+		c.OldRev = old.Rev().Nr()
+	} else if changeType != Added {
+		c.OldRev = new.Rev().Nr() - 1
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.publish(c)
+}
+
+//publish the change while the feed is locked
+func (f *Feed) publish(c Change) {
+	f.seq++
+	c.Seq = f.seq
+	if len(f.backlog) < f.size {
+		f.backlog = append(f.backlog, c)
+	} else {
+		f.backlog[f.first] = c
+		f.first = (f.first + 1) % f.size
+	}
+
+	for sub := range f.subs {
+		select {
+		case sub <- c:
+		default:
+			//too slow: drop the watcher, it can resume from its last change
+			f.drop(sub)
+		}
+	}
+}
+
+//drop a watcher while the feed is locked
+func (f *Feed) drop(sub chan Change) {
+	if done, ok := f.subs[sub]; ok {
+		delete(f.subs, sub)
+		close(sub)
+		close(done)
+	}
+}
+
+//Purge removes the item from the changes of an item in the backlog, so that its data is not kept,
+//and publishes that it was purged after revision lastRev
+//it must be called by table implementations when an item is purged
+func (f *Feed) Purge(uid string, lastRev int) {
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i := range f.backlog {
+		if f.backlog[i].UID == uid {
+			f.backlog[i].Item = nil
+		}
+	}
+	f.publish(Change{Type: Purged, UID: uid, OldRev: lastRev})
+}
+
+//Watch returns a channel that receives all changes after position from
+//from=0 receives only new changes
+//the channel is closed when ctx is done, or when the watcher falls too far behind,
+//after which it can watch again from the last Change.Seq it received
+func (f *Feed) Watch(ctx context.Context, from uint64) (<-chan Change, error) {
+	if f == nil {
+		return nil, fmt.Errorf("nil.Watch()")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	replay := make([]Change, 0)
+	if from > 0 {
+		if from > f.seq {
+			return nil, fmt.Errorf("cannot watch from %d beyond last change %d", from, f.seq)
+		}
+		if from < f.seq && (len(f.backlog) == 0 || f.backlog[f.first].Seq > from+1) {
+			return nil, fmt.Errorf("cannot watch from %d: no longer in the backlog", from)
+		}
+		for i := range f.backlog {
+			if c := f.backlog[(f.first+i)%len(f.backlog)]; c.Seq > from {
+				replay = append(replay, c)
+			}
+		}
+	}
+
+	sub := make(chan Change, len(replay)+f.buffer)
+	for _, c := range replay {
+		sub <- c
+	}
+	done := make(chan struct{})
+	f.subs[sub] = done
+	if ctx.Done() == nil {
+		//never done, so the watcher is only dropped when it is too slow
+		return sub, nil
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			f.drop(sub)
+		case <-done:
+			//dropped for being too slow
+		}
+	}()
+	return sub, nil
+} //Feed.Watch()
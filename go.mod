@@ -1,10 +1,12 @@
 module github.com/jansemmelink/items
 
+go 1.18
+
 require (
 	github.com/go-sql-driver/mysql v1.4.1
 	github.com/jansemmelink/log v0.1.0
 	github.com/jansemmelink/sql v0.1.0
-	github.com/pkg/errors v0.8.1
+	github.com/pkg/errors v0.9.1
 	github.com/prometheus/common v0.2.0
 	github.com/satori/go.uuid v1.2.0
 	google.golang.org/appengine v1.4.0 // indirect
@@ -0,0 +1,115 @@
+package items
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+//BeforeHook is called before a table write with the current revision (nil when adding)
+//and the proposed next revision of the item
+//it returns the data to write, which may be a modified copy of next.Data() e.g. to stamp fields,
+//or nil to write next.Data() unchanged, or an error to veto the write
+type BeforeHook func(cur IItem, next IItem) (IData, error)
+
+//AfterHook is called after a successful table write with the previous revision (nil when added)
+//and the revision that was written (for deletes, the deleted revision)
+type AfterHook func(old IItem, new IItem)
+
+//Hooks registered on a table
+//table implementations call Before() and After() around each write
+type Hooks struct {
+	mutex  sync.Mutex
+	before map[ChangeType][]BeforeHook
+	after  map[ChangeType][]AfterHook
+}
+
+//BeforeAdd registers a hook called before adding an item
+func (h *Hooks) BeforeAdd(fn BeforeHook) {
+	h.addBefore(Added, fn)
+}
+
+//BeforeUpd registers a hook called before updating an item
+func (h *Hooks) BeforeUpd(fn BeforeHook) {
+	h.addBefore(Updated, fn)
+}
+
+//BeforeDel registers a hook called before deleting an item
+func (h *Hooks) BeforeDel(fn BeforeHook) {
+	h.addBefore(Deleted, fn)
+}
+
+//AfterAdd registers a hook called after adding an item
+func (h *Hooks) AfterAdd(fn AfterHook) {
+	h.addAfter(Added, fn)
+}
+
+//AfterUpd registers a hook called after updating an item
+func (h *Hooks) AfterUpd(fn AfterHook) {
+	h.addAfter(Updated, fn)
+}
+
+//AfterDel registers a hook called after deleting an item
+func (h *Hooks) AfterDel(fn AfterHook) {
+	h.addAfter(Deleted, fn)
+}
+
+func (h *Hooks) addBefore(changeType ChangeType, fn BeforeHook) {
+	if fn == nil {
+		panic("nil BeforeHook")
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.before == nil {
+		h.before = make(map[ChangeType][]BeforeHook)
+	}
+	h.before[changeType] = append(h.before[changeType], fn)
+}
+
+func (h *Hooks) addAfter(changeType ChangeType, fn AfterHook) {
+	if fn == nil {
+		panic("nil AfterHook")
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.after == nil {
+		h.after = make(map[ChangeType][]AfterHook)
+	}
+	h.after[changeType] = append(h.after[changeType], fn)
+}
+
+//Before runs the before hooks of the change type in the order they were registered
+//and returns next with the data written by the hooks, or the first error
+func (h *Hooks) Before(changeType ChangeType, cur IItem, next IItem) (IItem, error) {
+	if h == nil {
+		return next, nil
+	}
+	h.mutex.Lock()
+	list := h.before[changeType]
+	h.mutex.Unlock()
+
+	for _, fn := range list {
+		data, err := fn(cur, next)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s of %s rejected", changeType, next.UID())
+		}
+		if data != nil {
+			next = WithCreated(NewItem(next.Table(), next.NID(), next.UID(), next.Rev(), data), next.Created())
+		}
+	}
+	return next, nil
+}
+
+//After runs the after hooks of the change type in the order they were registered
+func (h *Hooks) After(changeType ChangeType, old IItem, new IItem) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	list := h.after[changeType]
+	h.mutex.Unlock()
+
+	for _, fn := range list {
+		fn(old, new)
+	}
+}
@@ -1,6 +1,7 @@
 package items
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 
@@ -17,7 +18,9 @@ type IIndex interface {
 	MapKey(m map[string]interface{}) IKey
 	Add(IItem) error
 	FindOne(key map[string]interface{}) (IItem, error)
+	FindOneContext(ctx context.Context, key map[string]interface{}) (IItem, error)
 	Find(key map[string]interface{}) ([]IItem, error)
+	FindContext(ctx context.Context, key map[string]interface{}) ([]IItem, error)
 }
 
 type index struct {
@@ -113,9 +116,17 @@ func (i index) Add(IItem) error {
 }
 
 func (i index) FindOne(key map[string]interface{}) (IItem, error) {
+	return i.FindOneContext(context.Background(), key)
+}
+
+func (i index) FindOneContext(ctx context.Context, key map[string]interface{}) (IItem, error) {
 	return nil, fmt.Errorf("Index(%T:%s).FindOne not implemented", i, i.Name())
 }
 
 func (i index) Find(key map[string]interface{}) ([]IItem, error) {
+	return i.FindContext(context.Background(), key)
+}
+
+func (i index) FindContext(ctx context.Context, key map[string]interface{}) ([]IItem, error) {
 	return nil, fmt.Errorf("Index(%s).Find not implemented", i.Name())
 }
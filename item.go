@@ -1,6 +1,7 @@
 package items
 
 import (
+	"context"
 	"time"
 
 	"github.com/jansemmelink/log"
@@ -17,33 +18,65 @@ type IItem interface {
 	Rev() IRev
 	Data() IData
 
+	//Created is the revision that created the item, with its Timestamp() and Actor()
+	//it is nil if not known, e.g. for an item made with NewItem() after rev 1
+	Created() IRev
+	//Revisions is the number of revisions written for the item up to this revision,
+	//including revisions removed by ITable.Prune()
+	Revisions() int
+
 	//make and return the next revision
 	Upd(data IData) (IItem, error)
+	UpdContext(ctx context.Context, data IData) (IItem, error)
 
 	Del() error
+	DelContext(ctx context.Context) error
 }
 
 type item struct {
-	table ITable
-	nid   int
-	uid   string
-	rev   IRev
-	data  IData
+	table   ITable
+	nid     int
+	uid     string
+	rev     IRev
+	created IRev
+	data    IData
 }
 
 //NewItem ...
+//the item is its own creation revision when rev.Nr() is 1, else use WithCreated() to set it
 func NewItem(table ITable, nid int, uid string, rev IRev, data IData) IItem {
 	if table == nil || nid < 0 || len(uid) < 1 || rev.Nr() < 1 || data == nil {
 		log.Errorf("NewItem(%p,%d,%s,{%d},%p)", table, nid, uid, rev.Nr(), data)
 		return nil
 	}
-	return item{
+	i := item{
 		table: table,
 		nid:   nid,
 		uid:   uid,
 		rev:   rev,
 		data:  data,
 	}
+	if rev.Nr() == 1 {
+		i.created = CreatedRev(rev.Timestamp(), rev.Actor())
+	}
+	return i
+}
+
+//WithCreated returns the item with its creation revision, see IItem.Created()
+func WithCreated(i IItem, created IRev) IItem {
+	return item{
+		table:   i.Table(),
+		nid:     i.NID(),
+		uid:     i.UID(),
+		rev:     i.Rev(),
+		created: created,
+		data:    i.Data(),
+	}
+}
+
+//CreatedRev is the creation revision of an item as kept by all revisions of the item
+func CreatedRev(ts time.Time, actor string) IRev {
+	return rev{nr: 1, ts: ts, info: RevInfo{Actor: actor}}
 }
 
 func (i item) Table() ITable {
@@ -66,32 +99,54 @@ func (i item) Data() IData {
 	return i.data
 }
 
+func (i item) Created() IRev {
+	return i.created
+}
+
+func (i item) Revisions() int {
+	return i.rev.Nr()
+}
+
 func (i item) Upd(data IData) (IItem, error) {
-	//prepare the update using the next revision nr:
-	updatedItem := item{
-		table: i.table,
-		nid:   i.nid,
-		uid:   i.uid,
-		rev:   rev{i.rev.Nr() + 1, time.Now()},
-		data:  data,
-	}
+	return i.UpdContext(context.Background(), data)
+}
 
+func (i item) UpdContext(ctx context.Context, data IData) (IItem, error) {
 	//update in the table will fail if the item was already
 	//at or beyond this next revision
-	return i.table.UpdItem(updatedItem)
+	return i.table.UpdItemContext(ctx, NextItem(i, data))
 }
 
 func (i item) Del() error {
-	//prepare the old using the next revision nr:
-	deletedItem := item{
-		table: i.table,
-		nid:   i.nid,
-		uid:   i.uid,
-		rev:   rev{i.rev.Nr() + 1, time.Now()},
-		data:  i.data,
-	}
+	return i.DelContext(context.Background())
+}
 
+func (i item) DelContext(ctx context.Context) error {
 	//delete in the table will fail if the item was already
 	//at or beyond this next revision
-	return i.table.DelItem(deletedItem)
+	return i.table.DelItemContext(ctx, DeletedItem(i))
+}
+
+//NextItem prepares the next revision of an item with new data, to write with ITable.UpdItem()
+func NextItem(i IItem, data IData) IItem {
+	return item{
+		table:   i.Table(),
+		nid:     i.NID(),
+		uid:     i.UID(),
+		rev:     rev{nr: i.Rev().Nr() + 1, ts: i.Table().Db().Now()},
+		created: i.Created(),
+		data:    data,
+	}
+}
+
+//DeletedItem prepares the next revision of an item that deletes it, to write with ITable.DelItem()
+func DeletedItem(i IItem) IItem {
+	return item{
+		table:   i.Table(),
+		nid:     i.NID(),
+		uid:     i.UID(),
+		rev:     rev{nr: i.Rev().Nr() + 1, ts: i.Table().Db().Now(), deleted: true},
+		created: i.Created(),
+		data:    i.Data(),
+	}
 }
@@ -1,14 +1,16 @@
 package mem
 
 import (
+	"context"
+
 	"github.com/jansemmelink/items"
 )
 
 //New creates a new in-memory database
 //note: name is optional
-func New(name string) (items.IDb, error) {
+func New(name string, options ...items.Option) (items.IDb, error) {
 	return &memDatabase{
-		IDb: items.New(name),
+		IDb: items.New(name, options...),
 	}, nil
 }
 
@@ -18,17 +20,26 @@ type memDatabase struct {
 }
 
 func (db *memDatabase) Table(name string, tmplStruct items.IData) (items.ITable, error) {
+	return db.TableContext(context.Background(), name, tmplStruct)
+}
+
+func (db *memDatabase) TableContext(ctx context.Context, name string, tmplStruct items.IData) (items.ITable, error) {
 	//add the table to the db
-	it, err := db.IDb.Table(name, tmplStruct)
+	it, err := db.IDb.TableContext(ctx, name, tmplStruct)
 	if err != nil {
 		return nil, err
 	}
 
 	//describe the table
-	return &memTable{
-		ITable: it,
-		nextID: 1,
-		items:  make(map[string]items.IItem),
-		index:  make(map[string]items.IIndex),
-	}, nil
+	mt := &memTable{
+		ITable:  it,
+		nextID:  1,
+		items:   make(map[string]items.IItem),
+		history: make(map[string][]items.IItem),
+		nids:    make(map[int]string),
+		index:   make(map[string]*memIndex),
+		byField: make(map[string]map[interface{}]map[string]items.IItem),
+	}
+	db.SetTable(mt)
+	return mt, nil
 }
@@ -19,3 +19,11 @@ func TestDb(t *testing.T) {
 		t.Fatalf("db tests failed: %v", err)
 	}
 }
+
+func TestOptions(t *testing.T) {
+	if err := items.RunOptionTests(func(options ...items.Option) (items.IDb, error) {
+		return New("store", options...)
+	}); err != nil {
+		t.Fatalf("option tests failed: %v", err)
+	}
+}
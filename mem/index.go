@@ -1,6 +1,7 @@
 package mem
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/jansemmelink/items"
@@ -9,7 +10,8 @@ import (
 
 type memIndex struct {
 	items.IIndex
-	item map[string]items.IItem
+	table *memTable
+	item  map[string]items.IItem
 }
 
 func (i *memIndex) Add(item items.IItem) error {
@@ -19,25 +21,62 @@ func (i *memIndex) Add(item items.IItem) error {
 	if item == nil {
 		return fmt.Errorf("index(%s).Add(nil)", i.Name())
 	}
+	if err := i.check(item); err != nil {
+		return err
+	}
+	i.set(item)
+	return nil
+}
 
-	//make key string
+//check fails if the key of the item is used by another item that did not expire
+func (i *memIndex) check(item items.IItem) error {
 	keyString := i.ItemKey(item).String()
-	if _, ok := i.item[keyString]; ok {
-		return fmt.Errorf("duplicate key %s", keyString)
+	if existing, ok := i.item[keyString]; ok && existing.UID() != item.UID() && !i.table.TTL().Expired(existing, i.table.Db().Now) {
+		return &items.ErrDuplicateKey{Table: i.table.Name(), Index: i.Name(), Key: keyString}
 	}
-	i.item[keyString] = item
 	return nil
 }
 
-func (i memIndex) FindOne(key map[string]interface{}) (items.IItem, error) {
+func (i *memIndex) set(item items.IItem) {
+	i.item[i.ItemKey(item).String()] = item
+}
+
+//rem removes the key of the item if it still refers to the item
+func (i *memIndex) rem(item items.IItem) {
+	keyString := i.ItemKey(item).String()
+	if existing, ok := i.item[keyString]; ok && existing.UID() == item.UID() {
+		delete(i.item, keyString)
+	}
+}
+
+func (i *memIndex) FindOne(key map[string]interface{}) (items.IItem, error) {
+	return i.FindOneContext(context.Background(), key)
+}
+
+func (i *memIndex) FindOneContext(ctx context.Context, key map[string]interface{}) (items.IItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	i.table.applyDue(ctx)
+	i.table.mutex.Lock()
+	defer i.table.mutex.Unlock()
+
 	log.Debugf("Finding in list of %d items", len(i.item))
-	keyString := i.MapKey(key).String()
-	if item, ok := i.item[keyString]; ok {
-		return item, nil
+	return i.holder(i.MapKey(key).String()), nil
+}
+
+//holder returns the item with the key that did not expire, or nil, while the table is locked
+func (i *memIndex) holder(keyString string) items.IItem {
+	if item, ok := i.item[keyString]; ok && !i.table.TTL().Expired(item, i.table.Db().Now) {
+		return item
 	}
-	return nil, nil
+	return nil
+}
+
+func (i *memIndex) Find(key map[string]interface{}) ([]items.IItem, error) {
+	return i.FindContext(context.Background(), key)
 }
 
-func (i memIndex) Find(key map[string]interface{}) ([]items.IItem, error) {
+func (i *memIndex) FindContext(ctx context.Context, key map[string]interface{}) ([]items.IItem, error) {
 	return nil, fmt.Errorf("Index(%s).Find not implemented", i.Name())
 }
@@ -1,148 +1,1100 @@
 package mem
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/jansemmelink/items"
 	"github.com/pkg/errors"
-	uuid "github.com/satori/go.uuid"
 )
 
 type memTable struct {
 	items.ITable
-	mutex  sync.Mutex
-	nextID int
-	items  map[string]items.IItem
-	index  map[string]items.IIndex
+	mutex   sync.Mutex
+	nextID  int
+	items   map[string]items.IItem
+	history map[string][]items.IItem
+	nids    map[int]string
+	index   map[string]*memIndex
+	//byField has the items by field value of the fields used in ItemsWith(), by field name
+	byField map[string]map[interface{}]map[string]items.IItem
+	purges  []items.PurgeRecord
+	//scheduled revisions with the last id assigned
+	scheduled  []items.ScheduledRev
+	scheduleID int
+	//drafts with the last id assigned
+	drafts  []items.Draft
+	draftID int
 }
 
 func (t *memTable) Count() int {
 	if t == nil {
 		return 0
 	}
-	return len(t.items)
+	count, _ := t.CountContext(context.Background())
+	return count
+}
+
+func (t *memTable) CountContext(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	t.applyDue(ctx)
+	ttl := t.TTL()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	count := 0
+	for _, item := range t.items {
+		if !ttl.Expired(item, t.Db().Now) {
+			count++
+		}
+	}
+	return count, nil
 }
 
 func (t *memTable) AddItem(data items.IData) (items.IItem, error) {
+	return t.AddItemContext(context.Background(), data)
+}
+
+func (t *memTable) AddItemContext(ctx context.Context, data items.IData) (items.IItem, error) {
 	if t == nil {
 		return nil, fmt.Errorf("nil.AddItem()")
 	}
-	if data == nil {
-		return nil, fmt.Errorf("%s.AddItem(nil)", t.Name())
+	newItem, err := t.prepareAdd(ctx, data)
+	if err != nil {
+		return nil, err
 	}
-	if err := data.Validate(); err != nil {
-		return nil, errors.Wrapf(err, "invalid %v data", t.Type())
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+	written, _, errs := t.write([]items.IItem{newItem})
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	t.Hooks().After(items.Added, nil, written[0])
+	return written[0], nil
+}
 
-	newItem := items.NewItem(t, t.nextID, uuid.NewV1().String(), items.Rev(1, time.Now()), data)
-	for indexName, index := range t.index {
-		if err := index.Add(newItem); err != nil {
-			return nil, errors.Wrapf(err, "cannot add to index %s", indexName)
+func (t *memTable) AddItems(list []items.IData) ([]items.IItem, error) {
+	return t.AddItemsContext(context.Background(), list)
+}
+
+func (t *memTable) AddItemsContext(ctx context.Context, list []items.IData) ([]items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.AddItems()")
+	}
+	newItems := make([]items.IItem, len(list))
+	errs := make(map[int]error)
+	for n, data := range list {
+		newItem, err := t.prepareAdd(ctx, data)
+		if err != nil {
+			errs[n] = err
+			continue
 		}
+		newItems[n] = newItem
+	}
+	if len(errs) > 0 {
+		return nil, &items.BatchError{Table: t.Name(), Errs: errs}
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	written, _, errs := t.write(newItems)
+	if len(errs) > 0 {
+		return nil, &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	for _, newItem := range written {
+		t.Hooks().After(items.Added, nil, newItem)
+	}
+	return written, nil
+}
 
-	t.items[newItem.UID()] = newItem
-	t.nextID++
+//prepareAdd returns the new item to write for data
+//the nid is assigned when the item is written
+func (t *memTable) prepareAdd(ctx context.Context, data items.IData) (items.IItem, error) {
+	if data == nil {
+		return nil, fmt.Errorf("%s.AddItem(nil)", t.Name())
+	}
+	newItem := items.NewItem(t, 0, t.Db().NewUID(), items.NewRev(1, t.Db().Now(), false, items.RevInfoFrom(ctx)), data)
+	newItem, err := t.Hooks().Before(items.Added, nil, newItem)
+	if err != nil {
+		return nil, err
+	}
+	if err := newItem.Data().Validate(); err != nil {
+		return nil, &items.ErrInvalidData{Table: t.Name(), Err: err}
+	}
+	if err := items.CheckRefs(ctx, t, newItem.Data()); err != nil {
+		return nil, err
+	}
 	return newItem, nil
 }
 
 func (t *memTable) UpdItem(upd items.IItem) (items.IItem, error) {
+	return t.UpdItemContext(context.Background(), upd)
+}
+
+func (t *memTable) UpdItemContext(ctx context.Context, upd items.IItem) (items.IItem, error) {
 	if t == nil {
 		return nil, fmt.Errorf("nil.UpdItem()")
 	}
+	cur, upd, err := t.prepareUpd(ctx, upd)
+	if err != nil {
+		return nil, err
+	}
+	referring, err := items.UpdRefs(ctx, t, []items.IItem{cur}, []items.IItem{upd})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	written, replaced, errs := t.write([]items.IItem{upd})
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	t.Hooks().After(items.Updated, replaced[0], written[0])
+	return written[0], items.ApplyRefs(ctx, referring)
+}
+
+func (t *memTable) UpdItems(list []items.IItem) ([]items.IItem, error) {
+	return t.UpdItemsContext(context.Background(), list)
+}
+
+func (t *memTable) UpdItemsContext(ctx context.Context, list []items.IItem) ([]items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.UpdItems()")
+	}
+	curItems := make([]items.IItem, len(list))
+	updItems := make([]items.IItem, len(list))
+	errs := make(map[int]error)
+	for n, upd := range list {
+		cur, upd, err := t.prepareUpd(ctx, upd)
+		if err != nil {
+			errs[n] = err
+			continue
+		}
+		curItems[n] = cur
+		updItems[n] = upd
+	}
+	if len(errs) > 0 {
+		return nil, &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	referring, err := items.UpdRefs(ctx, t, curItems, updItems)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
+	written, replaced, errs := t.write(updItems)
+	if len(errs) > 0 {
+		return nil, &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	for n, upd := range written {
+		t.Hooks().After(items.Updated, replaced[n], upd)
+	}
+	return written, items.ApplyRefs(ctx, referring)
+}
+
+//prepareUpd checks the next revision of an item
+//and returns the current revision and the next revision with the data to write
+func (t *memTable) prepareUpd(ctx context.Context, upd items.IItem) (items.IItem, items.IItem, error) {
+	if upd == nil {
+		return nil, nil, fmt.Errorf("%s.UpdItem(nil)", t.Name())
+	}
 	//check table reference
 	if upd.Table() != t {
-		return nil, fmt.Errorf("%s.UpdItem(%d,%s) from other table(%s)", t.Name(), upd.NID(), upd.UID(), upd.Table().Name())
+		return nil, nil, fmt.Errorf("%s.UpdItem(%d,%s) from other table(%s)", t.Name(), upd.NID(), upd.UID(), upd.Table().Name())
 	}
 	//check valid rev nr
 	if upd.Rev().Nr() <= 1 {
-		return nil, fmt.Errorf("%s.UpdItem(%d,%s) with rev.nr=%d should be >1", t.Name(), upd.NID(), upd.UID(), upd.Rev().Nr())
+		return nil, nil, fmt.Errorf("%s.UpdItem(%d,%s) with rev.nr=%d should be >1", t.Name(), upd.NID(), upd.UID(), upd.Rev().Nr())
 	}
 
+	//make sure this will be the next rev
+	cur, err := t.GetItemContext(ctx, upd.UID())
+	if err != nil {
+		return nil, nil, err
+	}
+	if upd.Rev().Nr() != cur.Rev().Nr()+1 {
+		return nil, nil, &items.ErrRevisionConflict{Table: t.Name(), UID: upd.UID(), Expected: upd.Rev().Nr() - 1, Actual: cur.Rev().Nr()}
+	}
+	upd, err = t.Hooks().Before(items.Updated, cur, items.WithCreated(items.ApplyRevInfo(ctx, upd), cur.Created()))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := upd.Data().Validate(); err != nil {
+		return nil, nil, &items.ErrInvalidData{Table: t.Name(), Err: err}
+	}
+	if err := items.CheckRefs(ctx, t, upd.Data()); err != nil {
+		return nil, nil, err
+	}
+	return cur, upd, nil
+}
+
+func (t *memTable) GetItem(uid string) (items.IItem, error) {
+	if t == nil {
+		panic("nil.GetItem()")
+	}
+	return t.GetItemContext(context.Background(), uid)
+}
+
+func (t *memTable) GetItemContext(ctx context.Context, uid string) (items.IItem, error) {
+	return t.unexpired(t.current(ctx, uid))
+}
+
+//current gets the latest revision of an item, also when it expired
+func (t *memTable) current(ctx context.Context, uid string) (items.IItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.applyDue(ctx)
+
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	return t.get(uid)
+}
+
+//unexpired returns the item, or ErrDeleted if it expired, see items.TTL
+func (t *memTable) unexpired(item items.IItem, err error) (items.IItem, error) {
+	if err == nil && t.TTL().Expired(item, t.Db().Now) {
+		return nil, errors.Wrapf(items.ErrDeleted, "%s.uid=%s expired", t.Name(), item.UID())
+	}
+	return item, err
+}
+
+//get the latest revision of an item while the table is locked
+func (t *memTable) get(uid string) (items.IItem, error) {
+	if existing, ok := t.items[uid]; ok {
+		return existing, nil
+	}
+	if revs := t.history[uid]; len(revs) > 0 && revs[len(revs)-1].Rev().Deleted() {
+		return nil, errors.Wrapf(items.ErrDeleted, "%s.uid=%s", t.Name(), uid)
+	}
+	return nil, errors.Wrapf(items.ErrNotFound, "%s.uid=%s", t.Name(), uid)
+}
+
+func (t *memTable) GetItemByNID(nid int) (items.IItem, error) {
+	return t.GetItemByNIDContext(context.Background(), nid)
+}
 
-	//get current revision of existing item
-	cur, ok := t.items[upd.UID()]
+func (t *memTable) GetItemByNIDContext(ctx context.Context, nid int) (items.IItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.applyDue(ctx)
+
+	t.mutex.Lock()
+	uid, ok := t.nids[nid]
 	if !ok {
-		return nil, fmt.Errorf("%s.UpdItem(%d,%s) not found", t.Name(), upd.NID(), upd.UID())
+		t.mutex.Unlock()
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.nid=%d", t.Name(), nid)
+	}
+	item, err := t.get(uid)
+	t.mutex.Unlock()
+	return t.unexpired(item, err)
+}
+
+func (t *memTable) Restore(uid string) (items.IItem, error) {
+	return t.RestoreContext(context.Background(), uid)
+}
+
+func (t *memTable) RestoreContext(ctx context.Context, uid string) (items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.Restore()")
 	}
-	if cur.NID() != upd.NID() || cur.UID() != upd.UID() {
-		return nil, fmt.Errorf("%s.UpdItem(%d,%s) != CurItem(%d,%s)", t.Name(), upd.NID(), upd.UID(), cur.NID(), cur.UID())
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	revs := t.history[uid]
+	t.mutex.Unlock()
+	if len(revs) == 0 {
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.uid=%s", t.Name(), uid)
+	}
+	tombstone := revs[len(revs)-1]
+	if !tombstone.Rev().Deleted() {
+		return nil, fmt.Errorf("%s.uid=%s is not deleted", t.Name(), uid)
 	}
 
-	//make sure this will be the next rev
-	if upd.Rev().Nr() != cur.Rev().Nr()+1 {
-		return nil, fmt.Errorf("%s.UpdItem(%d,%s).Rev.Nr=%d should be %d", t.Name(), upd.NID(), upd.UID(), upd.Rev().Nr(), cur.Rev().Nr()+1)
+	//the deleted revision has the data of the item before it was deleted
+	restored := items.WithCreated(
+		items.NewItem(t, tombstone.NID(), uid, items.NewRev(tombstone.Rev().Nr()+1, t.Db().Now(), false, items.RevInfoFrom(ctx)), tombstone.Data()),
+		tombstone.Created())
+	restored, err := t.Hooks().Before(items.Added, nil, restored)
+	if err != nil {
+		return nil, err
+	}
+	if err := restored.Data().Validate(); err != nil {
+		return nil, &items.ErrInvalidData{Table: t.Name(), Err: err}
+	}
+	if err := items.CheckRefs(ctx, t, restored.Data()); err != nil {
+		return nil, err
 	}
 
-	//correct: replace
-	t.items[upd.UID()] = upd
-	return upd, nil
+	written, _, errs := t.write([]items.IItem{restored})
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	t.Hooks().After(items.Added, nil, written[0])
+	return written[0], nil
+} //memTable.RestoreContext()
+
+func (t *memTable) DelItem(old items.IItem) error {
+	return t.DelItemContext(context.Background(), old)
 }
 
-func (t *memTable) GetItem(uid string) items.IItem {
+func (t *memTable) DelItemContext(ctx context.Context, old items.IItem) error {
 	if t == nil {
-		panic("nil.GetItem()")
+		return fmt.Errorf("nil.DelItem()")
+	}
+	old, err := t.prepareDel(ctx, old)
+	if err != nil {
+		return err
+	}
+	referring, err := items.DelRefs(ctx, t, old)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	written, replaced, errs := t.write([]items.IItem{old})
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	t.Hooks().After(items.Deleted, replaced[0], written[0])
+	return items.ApplyRefs(ctx, referring)
+}
+
+func (t *memTable) DelItems(list []items.IItem) error {
+	return t.DelItemsContext(context.Background(), list)
+}
+
+func (t *memTable) DelItemsContext(ctx context.Context, list []items.IItem) error {
+	if t == nil {
+		return fmt.Errorf("nil.DelItems()")
+	}
+	delItems := make([]items.IItem, len(list))
+	errs := make(map[int]error)
+	for n, old := range list {
+		old, err := t.prepareDel(ctx, old)
+		if err != nil {
+			errs[n] = err
+			continue
+		}
+		delItems[n] = old
+	}
+	if len(errs) > 0 {
+		return &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	referring, err := items.DelRefs(ctx, t, delItems...)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
+	written, replaced, errs := t.write(delItems)
+	if len(errs) > 0 {
+		return &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	for n, tombstone := range written {
+		t.Hooks().After(items.Deleted, replaced[n], tombstone)
+	}
+	return items.ApplyRefs(ctx, referring)
+}
+
+//prepareDel checks the deleted revision of an item and returns it as approved by the hooks
+func (t *memTable) prepareDel(ctx context.Context, old items.IItem) (items.IItem, error) {
+	if old == nil {
+		return nil, fmt.Errorf("%s.DelItem(nil)", t.Name())
+	}
+	if old.Table() != t {
+		return nil, fmt.Errorf("%s.DelItem(nid=%d,uid=%s) from other table=%s", t.Name(), old.NID(), old.UID(), old.Table().Name())
+	}
+
+	//make sure this will be the next rev, also deleting an item that expired
+	cur, err := t.current(ctx, old.UID())
+	if err != nil {
+		return nil, err
+	}
+	if old.Rev().Nr() != cur.Rev().Nr()+1 {
+		return nil, &items.ErrRevisionConflict{Table: t.Name(), UID: old.UID(), Expected: old.Rev().Nr() - 1, Actual: cur.Rev().Nr()}
+	}
+	return t.Hooks().Before(items.Deleted, cur, items.WithCreated(items.ApplyRevInfo(ctx, old), cur.Created()))
+}
+
+//write stores revisions of items as one unit: new items (rev 1), updated, deleted or restored items
+//it returns the written revisions and the revisions they replaced (nil for new and restored items),
+//or the errors by position in the list without writing any of them
+func (t *memTable) write(list []items.IItem) ([]items.IItem, []items.IItem, map[int]error) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	return t.store(list)
+} //memTable.write()
 
-	if existing, ok := t.items[uid]; ok {
-		return existing
+//store writes the list like write() while the table is locked
+func (t *memTable) store(list []items.IItem) ([]items.IItem, []items.IItem, map[int]error) {
+	if errs := t.check(list); len(errs) > 0 {
+		return nil, nil, errs
 	}
-	return nil
+
+	written := make([]items.IItem, len(list))
+	replaced := make([]items.IItem, len(list))
+	for n, item := range list {
+		cur := t.items[item.UID()]
+		switch {
+		case item.Rev().Nr() == 1:
+			//new item with the next nid
+			item = items.NewItem(t, t.nextID, item.UID(), item.Rev(), item.Data())
+			t.nids[t.nextID] = item.UID()
+			t.nextID++
+			for _, index := range t.index {
+				index.set(item)
+			}
+			t.setFields(nil, item)
+			t.items[item.UID()] = item
+			t.history[item.UID()] = []items.IItem{item}
+			t.Feed().Publish(items.Added, nil, item)
+		case item.Rev().Deleted():
+			for _, index := range t.index {
+				index.rem(cur)
+			}
+			t.setFields(cur, nil)
+			delete(t.items, item.UID())
+			item = items.WithCreated(items.NewItem(t, item.NID(), item.UID(), items.NewRev(item.Rev().Nr(), item.Rev().Timestamp(), true, items.RevInfoOf(item.Rev())), item.Data()), item.Created())
+			t.history[item.UID()] = append(t.history[item.UID()], item)
+			t.Feed().Publish(items.Deleted, cur, item)
+		case cur == nil:
+			//restored item after its deleted revision
+			for _, index := range t.index {
+				index.set(item)
+			}
+			t.setFields(nil, item)
+			t.items[item.UID()] = item
+			t.history[item.UID()] = append(t.history[item.UID()], item)
+			t.Feed().Publish(items.Added, nil, item)
+		default:
+			for _, index := range t.index {
+				index.rem(cur)
+				index.set(item)
+			}
+			t.setFields(cur, item)
+			t.items[item.UID()] = item
+			t.history[item.UID()] = append(t.history[item.UID()], item)
+			t.Feed().Publish(items.Updated, cur, item)
+		}
+		written[n] = item
+		replaced[n] = cur
+	}
+	return written, replaced, nil
+} //memTable.store()
+
+//check that all revisions in the list can be written while the table is locked
+//and return the errors by position in the list
+func (t *memTable) check(list []items.IItem) map[int]error {
+	errs := make(map[int]error)
+	uids := make(map[string]int)
+	keys := make(map[string]int)
+	for n, item := range list {
+		if other, ok := uids[item.UID()]; ok {
+			errs[n] = fmt.Errorf("%s.uid=%s also written by entry %d", t.Name(), item.UID(), other)
+			continue
+		}
+		uids[item.UID()] = n
+
+		if item.Rev().Nr() > 1 {
+			//get latest revision of existing item, which is deleted when the item is restored
+			revs := t.history[item.UID()]
+			if len(revs) == 0 {
+				errs[n] = errors.Wrapf(items.ErrNotFound, "%s.uid=%s", t.Name(), item.UID())
+				continue
+			}
+			cur := revs[len(revs)-1]
+			if cur.Rev().Deleted() && item.Rev().Deleted() {
+				errs[n] = errors.Wrapf(items.ErrDeleted, "%s.uid=%s", t.Name(), item.UID())
+				continue
+			}
+			if cur.NID() != item.NID() {
+				errs[n] = fmt.Errorf("%s.uid=%s nid=%d != %d", t.Name(), item.UID(), item.NID(), cur.NID())
+				continue
+			}
+			//make sure this will be the next rev
+			if item.Rev().Nr() != cur.Rev().Nr()+1 {
+				errs[n] = &items.ErrRevisionConflict{Table: t.Name(), UID: item.UID(), Expected: item.Rev().Nr() - 1, Actual: cur.Rev().Nr()}
+				continue
+			}
+		}
+		if item.Rev().Deleted() {
+			continue
+		}
+
+		//the key must not be used by another item in the table or the list
+		for indexName, index := range t.index {
+			if err := index.check(item); err != nil {
+				errs[n] = err
+				break
+			}
+			keyString := index.ItemKey(item).String()
+			if _, ok := keys[indexName+":"+keyString]; ok {
+				errs[n] = &items.ErrDuplicateKey{Table: t.Name(), Index: indexName, Key: keyString}
+				break
+			}
+			keys[indexName+":"+keyString] = n
+		}
+	}
+	return errs
+} //memTable.check()
+
+func (t *memTable) History(uid string) ([]items.IItem, error) {
+	return t.HistoryContext(context.Background(), uid)
 }
 
-func (t *memTable) DelItem(old items.IItem) error {
+func (t *memTable) HistoryContext(ctx context.Context, uid string) ([]items.IItem, error) {
 	if t == nil {
-		return fmt.Errorf("nil.DelItem()")
+		return nil, fmt.Errorf("nil.History()")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	t.applyDue(ctx)
 
-	if old.Table() != t {
-		return fmt.Errorf("%s.DelItem(nid=%d,uid=%s) from other table=%s", t.Name(), old.NID(), old.UID(), old.Table().Name())
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	revs, ok := t.history[uid]
+	if !ok {
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.History(%s)", t.Name(), uid)
+	}
+	list := make([]items.IItem, len(revs))
+	copy(list, revs)
+	return list, nil
+}
+
+func (t *memTable) Prune() (int, error) {
+	return t.PruneContext(context.Background())
+}
+
+func (t *memTable) PruneContext(ctx context.Context) (int, error) {
+	if t == nil {
+		return 0, fmt.Errorf("nil.Prune()")
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	retention := t.Retention()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.Db().Now()
+	count := 0
+	for uid, list := range t.history {
+		revs := make([]items.IRev, len(list))
+		for i, item := range list {
+			revs[i] = item.Rev()
+		}
+		drop := retention.Drop(revs, now)
+		if len(drop) == 0 {
+			continue
+		}
+		keep := make([]items.IItem, 0, len(list)-len(drop))
+		for _, item := range list {
+			if len(drop) > 0 && item.Rev().Nr() == drop[0] {
+				drop = drop[1:]
+				count++
+				continue
+			}
+			keep = append(keep, item)
+		}
+		t.history[uid] = keep
 	}
+	return count, nil
+}
 
+func (t *memTable) Items() map[string]items.IItem {
+	list, _ := t.ItemsContext(context.Background())
+	return list
+}
+
+func (t *memTable) ItemsContext(ctx context.Context) (map[string]items.IItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.applyDue(ctx)
+	ttl := t.TTL()
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	list := make(map[string]items.IItem, len(t.items))
+	for uid, item := range t.items {
+		if !ttl.Expired(item, t.Db().Now) {
+			list[uid] = item
+		}
+	}
+	return list, nil
+}
+
+func (t *memTable) ItemsWith(field string, value interface{}) (map[string]items.IItem, error) {
+	return t.ItemsWithContext(context.Background(), field, value)
+}
 
-	//get current revision of existing item
-	cur, ok := t.items[old.UID()]
+func (t *memTable) ItemsWithContext(ctx context.Context, field string, value interface{}) (map[string]items.IItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := t.Type().FieldByName(field); !ok {
+		return nil, fmt.Errorf("table %s does not have field %s", t.Name(), field)
+	}
+	t.applyDue(ctx)
+	ttl := t.TTL()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	values, ok := t.byField[field]
 	if !ok {
-		return fmt.Errorf("%s.DelItem(%d,%s) not found", t.Name(), old.NID(), old.UID())
+		//look up the field from now on, while the items are written
+		values = make(map[interface{}]map[string]items.IItem)
+		t.byField[field] = values
+		for _, item := range t.items {
+			addField(values, field, item)
+		}
 	}
-	if cur.NID() != old.NID() || cur.UID() != old.UID() {
-		return fmt.Errorf("%s.DelItem(%d,%s) != CurItem(%d,%s)", t.Name(), old.NID(), old.UID(), cur.NID(), cur.UID())
+	list := make(map[string]items.IItem, len(values[value]))
+	for uid, item := range values[value] {
+		if !ttl.Expired(item, t.Db().Now) {
+			list[uid] = item
+		}
 	}
+	return list, nil
+} //memTable.ItemsWithContext()
 
-	//make sure this will be the next rev
-	if old.Rev().Nr() != cur.Rev().Nr()+1 {
-		return fmt.Errorf("%s.DelItem(%d,%s).Rev.Nr=%d should be %d", t.Name(), old.NID(), old.UID(), old.Rev().Nr(), cur.Rev().Nr()+1)
+//setFields updates the items by field value when cur is replaced by item, where either may be nil
+//while the table is locked
+func (t *memTable) setFields(cur items.IItem, item items.IItem) {
+	for field, values := range t.byField {
+		if cur != nil {
+			value := reflect.ValueOf(cur.Data()).FieldByName(field).Interface()
+			delete(values[value], cur.UID())
+			if len(values[value]) == 0 {
+				delete(values, value)
+			}
+		}
+		if item != nil {
+			addField(values, field, item)
+		}
 	}
+}
 
-	//correct: delete
-	//log.Debugf("Mark as deleted rev %d", old.Rev().Nr())
-	delete(t.items, old.UID())
-	return nil
+func addField(values map[interface{}]map[string]items.IItem, field string, item items.IItem) {
+	value := reflect.ValueOf(item.Data()).FieldByName(field).Interface()
+	if values[value] == nil {
+		values[value] = make(map[string]items.IItem)
+	}
+	values[value][item.UID()] = item
 }
 
-func (t *memTable) Items() map[string]items.IItem {
-	return t.items
+func (t *memTable) ItemsAsOf(knownAt time.Time, validAt time.Time) (map[string]items.IItem, error) {
+	return t.ItemsAsOfContext(context.Background(), knownAt, validAt)
+}
+
+func (t *memTable) ItemsAsOfContext(ctx context.Context, knownAt time.Time, validAt time.Time) (map[string]items.IItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.applyDue(ctx)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list := make(map[string]items.IItem)
+	for uid, revs := range t.history {
+		if item := items.RevisionAsOf(revs, knownAt, validAt); item != nil && !item.Rev().Deleted() {
+			list[uid] = item
+		}
+	}
+	return list, nil
+}
+
+func (t *memTable) Purge(uid string) error {
+	return t.PurgeContext(context.Background(), uid)
+}
+
+func (t *memTable) PurgeContext(ctx context.Context, uid string) error {
+	if t == nil {
+		return fmt.Errorf("nil.Purge()")
+	}
+	t.mutex.Lock()
+	_, ok := t.history[uid]
+	t.mutex.Unlock()
+	if !ok {
+		return errors.Wrapf(items.ErrNotFound, "%s.uid=%s", t.Name(), uid)
+	}
+	_, err := t.purge(ctx, []string{uid}, false)
+	return err
+}
+
+func (t *memTable) PurgeAll() error {
+	return t.PurgeAllContext(context.Background())
+}
+
+func (t *memTable) PurgeAllContext(ctx context.Context) error {
+	if t == nil {
+		return fmt.Errorf("nil.PurgeAll()")
+	}
+	t.mutex.Lock()
+	uids := make([]string, 0, len(t.history))
+	for uid := range t.history {
+		uids = append(uids, uid)
+	}
+	t.mutex.Unlock()
+	_, err := t.purge(ctx, uids, false)
+	return err
+}
+
+//purge removes all revisions of the items and returns how many were purged
+//references to items that are not deleted are handled like when they are deleted
+//when expired is true, items that are no longer expired when they are purged, are kept
+func (t *memTable) purge(ctx context.Context, uids []string, expired bool) (int, error) {
+	t.mutex.Lock()
+	live := make([]items.IItem, 0)
+	for _, uid := range uids {
+		if item, ok := t.items[uid]; ok {
+			live = append(live, item)
+		}
+	}
+	t.mutex.Unlock()
+	referring, err := items.DelRefs(ctx, t, live...)
+	if err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	t.mutex.Lock()
+	if expired {
+		//written since it was found expired
+		ttl := t.TTL()
+		still := make([]string, 0, len(uids))
+		for _, uid := range uids {
+			if item, ok := t.items[uid]; ok && ttl.Expired(item, t.Db().Now) {
+				still = append(still, uid)
+			}
+		}
+		uids = still
+	}
+	purged := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		purged[uid] = true
+	}
+	scheduled := make([]items.ScheduledRev, 0, len(t.scheduled))
+	for _, s := range t.scheduled {
+		if !purged[s.UID] {
+			scheduled = append(scheduled, s)
+		}
+	}
+	t.scheduled = scheduled
+	drafts := make([]items.Draft, 0, len(t.drafts))
+	for _, d := range t.drafts {
+		if !purged[d.UID] {
+			drafts = append(drafts, d)
+		}
+	}
+	t.drafts = drafts
+	for _, uid := range uids {
+		revs := t.history[uid]
+		if len(revs) == 0 {
+			continue //already purged
+		}
+		if cur, ok := t.items[uid]; ok {
+			for _, index := range t.index {
+				index.rem(cur)
+			}
+			t.setFields(cur, nil)
+			delete(t.items, uid)
+		}
+		latest := revs[len(revs)-1]
+		delete(t.history, uid)
+		delete(t.nids, latest.NID())
+		t.purges = append(t.purges, items.NewPurgeRecord(ctx, t, uid, latest.NID(), len(revs)))
+		t.Feed().Purge(uid, latest.Rev().Nr())
+	}
+	t.mutex.Unlock()
+	return len(uids), items.ApplyRefs(ctx, referring)
+} //memTable.purge()
+
+func (t *memTable) Purges() ([]items.PurgeRecord, error) {
+	return t.PurgesContext(context.Background())
+}
+
+func (t *memTable) PurgesContext(ctx context.Context) ([]items.PurgeRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list := make([]items.PurgeRecord, len(t.purges))
+	copy(list, t.purges)
+	return list, nil
+}
+
+func (t *memTable) Sweep() (int, error) {
+	return t.SweepContext(context.Background())
+}
+
+func (t *memTable) SweepContext(ctx context.Context) (int, error) {
+	if t == nil {
+		return 0, fmt.Errorf("nil.Sweep()")
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	ttl := t.TTL()
+	t.mutex.Lock()
+	expired := make([]items.IItem, 0)
+	for _, item := range t.items {
+		if ttl.Expired(item, t.Db().Now) {
+			expired = append(expired, item)
+		}
+	}
+	t.mutex.Unlock()
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	if ttl.Purge {
+		uids := make([]string, len(expired))
+		for n, item := range expired {
+			uids[n] = item.UID()
+		}
+		return t.purge(ctx, uids, true)
+	}
+	if items.RevInfoFrom(ctx).Reason == "" {
+		ctx = items.WithReason(ctx, items.ExpiredReason)
+	}
+	count := 0
+	for _, item := range expired {
+		if err := t.DelItemContext(ctx, items.DeletedItem(item)); err != nil {
+			var conflict *items.ErrRevisionConflict
+			if errors.As(err, &conflict) || errors.Is(err, items.ErrDeleted) {
+				continue //written in the meantime
+			}
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+} //memTable.SweepContext()
+
+func (t *memTable) Schedule(upd items.IItem, at time.Time) (items.ScheduledRev, error) {
+	return t.ScheduleContext(context.Background(), upd, at)
+}
+
+func (t *memTable) ScheduleContext(ctx context.Context, upd items.IItem, at time.Time) (items.ScheduledRev, error) {
+	if t == nil {
+		return items.ScheduledRev{}, fmt.Errorf("nil.Schedule()")
+	}
+	s, err := items.NewScheduledRev(ctx, t, upd, at)
+	if err != nil {
+		return items.ScheduledRev{}, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.scheduleID++
+	s.ID = t.scheduleID
+	t.scheduled = append(t.scheduled, s)
+	return s, nil
+}
+
+func (t *memTable) Scheduled() ([]items.ScheduledRev, error) {
+	return t.ScheduledContext(context.Background())
+}
+
+func (t *memTable) ScheduledContext(ctx context.Context) ([]items.ScheduledRev, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.applyDue(ctx)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list := make([]items.ScheduledRev, len(t.scheduled))
+	copy(list, t.scheduled)
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].At.Equal(list[j].At) {
+			return list[i].At.Before(list[j].At)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (t *memTable) CancelScheduled(id int) error {
+	return t.CancelScheduledContext(context.Background(), id)
+}
+
+func (t *memTable) CancelScheduledContext(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for n, s := range t.scheduled {
+		if s.ID == id {
+			t.scheduled = append(t.scheduled[:n], t.scheduled[n+1:]...)
+			return nil
+		}
+	}
+	return errors.Wrapf(items.ErrNotFound, "%s.scheduled=%d", t.Name(), id)
+}
+
+//applyDue writes the scheduled revisions that are due, before the table is read
+//revisions that cannot be written are kept with their failure
+func (t *memTable) applyDue(ctx context.Context) {
+	t.mutex.Lock()
+	pending := false
+	for _, s := range t.scheduled {
+		if s.Failure == "" {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		//do not look at the clock when there is nothing to do
+		t.mutex.Unlock()
+		return
+	}
+	now := t.Db().Now()
+	due := make([]items.ScheduledRev, 0)
+	keep := make([]items.ScheduledRev, 0, len(t.scheduled))
+	for _, s := range t.scheduled {
+		if s.Due(now) {
+			due = append(due, s)
+		} else {
+			keep = append(keep, s)
+		}
+	}
+	t.scheduled = keep
+	t.mutex.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].At.Before(due[j].At) })
+	for _, s := range due {
+		if _, err := s.Write(ctx, t); err != nil {
+			if ctx.Err() == nil {
+				s.Failure = err.Error()
+			}
+			t.mutex.Lock()
+			t.scheduled = append(t.scheduled, s)
+			t.mutex.Unlock()
+		}
+	}
+} //memTable.applyDue()
+
+func (t *memTable) Propose(upd items.IItem) (items.Draft, error) {
+	return t.ProposeContext(context.Background(), upd)
 }
 
-func (t *memTable) DelAll() error {
-	t.items = make(map[string]items.IItem)
+func (t *memTable) ProposeContext(ctx context.Context, upd items.IItem) (items.Draft, error) {
+	if t == nil {
+		return items.Draft{}, fmt.Errorf("nil.Propose()")
+	}
+	d, err := items.NewDraft(ctx, t, upd)
+	if err != nil {
+		return items.Draft{}, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.draftID++
+	d.ID = t.draftID
+	t.drafts = append(t.drafts, d)
+	return d, nil
+}
+
+func (t *memTable) Drafts() ([]items.Draft, error) {
+	return t.DraftsContext(context.Background())
+}
+
+func (t *memTable) DraftsContext(ctx context.Context) ([]items.Draft, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list := make([]items.Draft, len(t.drafts))
+	copy(list, t.drafts)
+	return list, nil
+}
+
+func (t *memTable) Approve(id int) (items.IItem, error) {
+	return t.ApproveContext(context.Background(), id)
+}
+
+func (t *memTable) ApproveContext(ctx context.Context, id int) (items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.Approve()")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	//the draft is taken before it is written, so it cannot be approved or rejected at the same time
+	d, ok := t.draft(id, true)
+	if !ok {
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.draft=%d", t.Name(), id)
+	}
+	written, err := d.Approve(ctx, t)
+	if err != nil {
+		t.mutex.Lock()
+		n := sort.Search(len(t.drafts), func(i int) bool { return t.drafts[i].ID > d.ID })
+		t.drafts = append(t.drafts[:n], append([]items.Draft{d}, t.drafts[n:]...)...)
+		t.mutex.Unlock()
+		return nil, err
+	}
+	return written, nil
+}
+
+func (t *memTable) Reject(id int) error {
+	return t.RejectContext(context.Background(), id)
+}
+
+func (t *memTable) RejectContext(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := t.draft(id, true); !ok {
+		return errors.Wrapf(items.ErrNotFound, "%s.draft=%d", t.Name(), id)
+	}
 	return nil
 }
 
+//draft returns the draft with the id, and removes it from the table if remove is true
+func (t *memTable) draft(id int, remove bool) (items.Draft, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for n, d := range t.drafts {
+		if d.ID == id {
+			if remove {
+				t.drafts = append(t.drafts[:n], t.drafts[n+1:]...)
+			}
+			return d, true
+		}
+	}
+	return items.Draft{}, false
+}
+
 func (t *memTable) Index(name string, fieldNames []string) (items.IIndex, error) {
+	return t.IndexContext(context.Background(), name, fieldNames)
+}
+
+func (t *memTable) IndexContext(ctx context.Context, name string, fieldNames []string) (items.IIndex, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 	if _, ok := t.index[name]; ok {
@@ -157,6 +1109,7 @@ func (t *memTable) Index(name string, fieldNames []string) (items.IIndex, error)
 	//add the index to the table
 	mi := &memIndex{
 		IIndex: newIndex,
+		table:  t,
 		item:   make(map[string]items.IItem),
 	}
 
@@ -171,3 +1124,12 @@ func (t *memTable) Index(name string, fieldNames []string) (items.IIndex, error)
 	t.index[name] = mi
 	return mi, nil
 }
+
+func (t *memTable) GetIndex(name string) items.IIndex {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if index, ok := t.index[name]; ok {
+		return index
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package mem
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jansemmelink/items"
+	"github.com/pkg/errors"
+)
+
+func (t *memTable) Upsert(indexName string, data items.IData) (items.IItem, bool, error) {
+	return t.UpsertContext(context.Background(), indexName, data)
+}
+
+func (t *memTable) UpsertContext(ctx context.Context, indexName string, data items.IData) (items.IItem, bool, error) {
+	return t.upsert(ctx, indexName, data, true)
+}
+
+func (t *memTable) GetOrCreate(indexName string, data items.IData) (items.IItem, bool, error) {
+	return t.GetOrCreateContext(context.Background(), indexName, data)
+}
+
+func (t *memTable) GetOrCreateContext(ctx context.Context, indexName string, data items.IData) (items.IItem, bool, error) {
+	return t.upsert(ctx, indexName, data, false)
+}
+
+//upsert finds the item with the key of data in the index and updates it (when update is true) or returns it,
+//else it adds data as a new item and returns true
+//the revision is prepared without the lock, because the hooks may read the table, and then written under the lock
+//only if the key still belongs to the same item (or none), else it is prepared again for the item that has the key now,
+//according to the retry policy of the table
+func (t *memTable) upsert(ctx context.Context, indexName string, data items.IData, update bool) (items.IItem, bool, error) {
+	if t == nil {
+		return nil, false, fmt.Errorf("nil.Upsert()")
+	}
+	if data == nil {
+		return nil, false, fmt.Errorf("%s.Upsert(%s,nil)", t.Name(), indexName)
+	}
+	t.mutex.Lock()
+	index, ok := t.index[indexName]
+	t.mutex.Unlock()
+	if !ok {
+		return nil, false, fmt.Errorf("table %s does not have index %s", t.Name(), indexName)
+	}
+	dataValue := reflect.ValueOf(data)
+	key := make(map[string]interface{})
+	for _, fieldName := range index.Fields() {
+		key[fieldName] = dataValue.FieldByName(fieldName).Interface()
+	}
+	keyString := index.MapKey(key).String()
+	retry := t.Retry()
+	if retry.Attempts <= 0 {
+		retry = items.DefaultRetry
+	}
+
+	backoff := retry.Backoff
+	for attempt := 1; ; attempt++ {
+		item, created, again, err := t.upsertOnce(ctx, index, keyString, data, update)
+		if !again {
+			return item, created, err
+		}
+		if attempt >= retry.Attempts {
+			return nil, false, &items.ErrRetriesExhausted{Table: t.Name(), UID: keyString, Attempts: attempt, Err: err}
+		}
+
+		//wait before finding the item again
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+} //memTable.upsert()
+
+//upsertOnce is one attempt of upsert(), which returns again=true with the reason in err
+//when the item with the key was written in the meantime
+func (t *memTable) upsertOnce(ctx context.Context, index *memIndex, keyString string, data items.IData, update bool) (items.IItem, bool, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, false, err
+	}
+	t.applyDue(ctx)
+	t.mutex.Lock()
+	cur := index.holder(keyString)
+	t.mutex.Unlock()
+	if cur != nil && !update {
+		return cur, false, false, nil
+	}
+
+	var next items.IItem
+	var referring []items.Referring
+	var err error
+	if cur == nil {
+		next, err = t.prepareAdd(ctx, data)
+	} else if _, next, err = t.prepareUpd(ctx, items.NextItem(cur, data)); err == nil {
+		referring, err = items.UpdRefs(ctx, t, []items.IItem{cur}, []items.IItem{next})
+	}
+	var conflict *items.ErrRevisionConflict
+	if errors.As(err, &conflict) || errors.Is(err, items.ErrDeleted) || errors.Is(err, items.ErrNotFound) {
+		return nil, false, true, err
+	}
+	if err != nil {
+		return nil, false, false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, false, err
+	}
+
+	t.mutex.Lock()
+	if holder := index.holder(keyString); (holder == nil) != (cur == nil) ||
+		(holder != nil && (holder.UID() != cur.UID() || holder.Rev().Nr() != cur.Rev().Nr())) {
+		t.mutex.Unlock()
+		return nil, false, true, &items.ErrDuplicateKey{Table: t.Name(), Index: index.Name(), Key: keyString}
+	}
+	written, replaced, errs := t.store([]items.IItem{next})
+	t.mutex.Unlock()
+	if len(errs) > 0 {
+		return nil, false, false, errs[0]
+	}
+	if cur == nil {
+		t.Hooks().After(items.Added, nil, written[0])
+		return written[0], true, false, nil
+	}
+	t.Hooks().After(items.Updated, replaced[0], written[0])
+	return written[0], false, false, items.ApplyRefs(ctx, referring)
+} //memTable.upsertOnce()
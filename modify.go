@@ -0,0 +1,74 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//ModifyFunc returns the data to write as the next revision of an item, given the data of its latest revision
+//it may be called more than once by Modify(), so it must not have side effects
+type ModifyFunc func(current IData) (IData, error)
+
+//Retry policy of ITable.Modify()
+type Retry struct {
+	//Attempts is the max nr of times to get, modify and update the item (0 to use DefaultRetry)
+	Attempts int
+	//Backoff is the time to wait before the second attempt, doubled before each next attempt
+	Backoff time.Duration
+}
+
+//DefaultRetry is used by tables that have no retry policy
+var DefaultRetry = Retry{Attempts: 5, Backoff: 10 * time.Millisecond}
+
+func (t *table) Modify(uid string, fn ModifyFunc) (IItem, error) {
+	return t.ModifyContext(context.Background(), uid, fn)
+}
+
+func (t *table) ModifyContext(ctx context.Context, uid string, fn ModifyFunc) (IItem, error) {
+	if fn == nil {
+		return nil, fmt.Errorf("%s.Modify(%s,nil)", t.name, uid)
+	}
+	//use the table as registered in the db, which wraps this table
+	self := t.db.GetTable(t.name)
+	if self == nil {
+		return nil, fmt.Errorf("db(%s).table(%s) not found", t.db.Name(), t.name)
+	}
+	retry := t.Retry()
+	if retry.Attempts <= 0 {
+		retry = DefaultRetry
+	}
+
+	backoff := retry.Backoff
+	var conflict *ErrRevisionConflict
+	for attempt := 1; ; attempt++ {
+		cur, err := self.GetItemContext(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		data, err := fn(cur.Data())
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s.%s not modified", t.name, uid)
+		}
+		upd, err := cur.UpdContext(ctx, data)
+		if err == nil {
+			return upd, nil
+		}
+		if !errors.As(err, &conflict) {
+			return nil, err
+		}
+		if attempt >= retry.Attempts {
+			return nil, &ErrRetriesExhausted{Table: t.name, UID: uid, Attempts: attempt, Err: err}
+		}
+
+		//wait before getting the item again
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+} //table.ModifyContext()
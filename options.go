@@ -0,0 +1,43 @@
+package items
+
+import (
+	"sync"
+	"time"
+)
+
+//Option configures a database when it is created, e.g. mem.New(name, items.WithClock(clock))
+type Option func(d *Database)
+
+//Clock returns the current time, used for the timestamps of new revisions
+type Clock func() time.Time
+
+//WithClock is an option to timestamp revisions with the clock instead of time.Now
+func WithClock(clock Clock) Option {
+	return func(d *Database) {
+		if clock != nil {
+			d.clock = clock
+		}
+	}
+}
+
+//WithUIDs is an option to assign uids to new items from the generator instead of UUIDv4()
+func WithUIDs(uids UIDGenerator) Option {
+	return func(d *Database) {
+		if uids != nil {
+			d.uids = uids
+		}
+	}
+}
+
+//StepClock returns a clock for tests that starts at start and advances by step on each call
+func StepClock(start time.Time, step time.Duration) Clock {
+	next := start
+	var mutex sync.Mutex
+	return func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now := next
+		next = next.Add(step)
+		return now
+	}
+}
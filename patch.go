@@ -0,0 +1,51 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+func (t *table) Patch(item IItem, fields map[string]interface{}) (IItem, error) {
+	return t.PatchContext(context.Background(), item, fields)
+}
+
+func (t *table) PatchContext(ctx context.Context, item IItem, fields map[string]interface{}) (IItem, error) {
+	if item == nil {
+		return nil, fmt.Errorf("%s.Patch(nil)", t.name)
+	}
+	//the item must be from the table as registered in the db, which wraps this table
+	if item.Table() != t.db.GetTable(t.name) {
+		return nil, fmt.Errorf("%s.Patch(%s) from other table(%s)", t.name, item.UID(), item.Table().Name())
+	}
+	data, err := Patched(t.schema, item.Data(), fields)
+	if err != nil {
+		return nil, &ErrInvalidData{Table: t.name, Err: err}
+	}
+	return item.UpdContext(ctx, data)
+}
+
+//Patched returns a copy of data with the named fields set to the values converted
+//to the field types with ConvertValue()
+//it fails if a field is not in the schema
+func Patched(s ISchema, data IData, fields map[string]interface{}) (IData, error) {
+	if reflect.TypeOf(data) != s.Type() {
+		return nil, fmt.Errorf("cannot patch %T as %v", data, s.Type())
+	}
+	dataPtrValue := reflect.New(s.Type())
+	dataPtrValue.Elem().Set(reflect.ValueOf(data))
+	for name, value := range fields {
+		structField, ok := s.Field(name)
+		if !ok {
+			return nil, fmt.Errorf("%v does not have field %s", s.Type(), name)
+		}
+		fieldValue, err := ConvertValue(value, structField.Type)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value for %s", name)
+		}
+		dataPtrValue.Elem().Field(structField.Index[0]).Set(reflect.ValueOf(fieldValue))
+	}
+	return dataPtrValue.Elem().Interface().(IData), nil
+}
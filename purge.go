@@ -0,0 +1,57 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//PurgeRecord is kept for each item removed with ITable.Purge()
+//it does not have any of the item data
+type PurgeRecord struct {
+	UID string
+	NID int
+	//Revisions is the nr of revisions that were removed
+	Revisions int
+	//Timestamp, Actor and Reason of the purge, see WithActor() and WithReason()
+	Timestamp time.Time
+	Actor     string
+	Reason    string
+}
+
+//NewPurgeRecord for the item uid and nid of which n revisions are purged in the context
+func NewPurgeRecord(ctx context.Context, t ITable, uid string, nid int, n int) PurgeRecord {
+	info := RevInfoFrom(ctx)
+	return PurgeRecord{
+		UID:       uid,
+		NID:       nid,
+		Revisions: n,
+		Timestamp: t.Db().Now(),
+		Actor:     info.Actor,
+		Reason:    info.Reason,
+	}
+}
+
+func (t *table) Purge(uid string) error {
+	return t.PurgeContext(context.Background(), uid)
+}
+
+func (t *table) PurgeContext(ctx context.Context, uid string) error {
+	return fmt.Errorf("db(%s).table(%s).Purge() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) PurgeAll() error {
+	return t.PurgeAllContext(context.Background())
+}
+
+func (t *table) PurgeAllContext(ctx context.Context) error {
+	return fmt.Errorf("db(%s).table(%s).PurgeAll() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) Purges() ([]PurgeRecord, error) {
+	return t.PurgesContext(context.Background())
+}
+
+func (t *table) PurgesContext(ctx context.Context) ([]PurgeRecord, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).Purges() not implemented", t.db.Name(), t.name)
+}
@@ -0,0 +1,282 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+//RefAction determines what happens to referring items when a referenced item is deleted
+type RefAction int
+
+//Ref actions
+const (
+	//RefReject fails the delete while the item is referenced
+	RefReject RefAction = iota
+	//RefCascade deletes the referring items as well
+	RefCascade
+	//RefSetNull updates the referring items to set the field to its zero value
+	RefSetNull
+)
+
+func (a RefAction) String() string {
+	switch a {
+	case RefReject:
+		return "reject"
+	case RefCascade:
+		return "cascade"
+	case RefSetNull:
+		return "setnull"
+	}
+	return fmt.Sprintf("RefAction(%d)", int(a))
+}
+
+//Ref describes a field in one table that refers to an item in another table of the same IDb
+//a zero field value does not refer to anything
+type Ref struct {
+	Table ITable
+	Field string
+	//Target table and the single field unique index in it, or "" to refer to the item uid
+	Target      ITable
+	TargetIndex string
+	OnDel       RefAction
+}
+
+//refs is shared by all tables of a database
+type refs struct {
+	mutex sync.Mutex
+	list  []Ref
+}
+
+func (r *refs) add(ref Ref) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.list = append(r.list, ref)
+}
+
+//find the refs from and/or to the named tables ("" for any)
+func (r *refs) find(from string, to string) []Ref {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	list := make([]Ref, 0)
+	for _, ref := range r.list {
+		if (from == "" || ref.Table.Name() == from) && (to == "" || ref.Target.Name() == to) {
+			list = append(list, ref)
+		}
+	}
+	return list
+}
+
+//newRef checks that the ref can be declared from table t
+func newRef(db IDb, t ITable, field string, target ITable, targetIndex string, onDel RefAction) (Ref, error) {
+	if target == nil {
+		return Ref{}, fmt.Errorf("%s.Ref(%s) to nil table", t.Name(), field)
+	}
+	if db.GetTable(target.Name()) != target {
+		return Ref{}, fmt.Errorf("%s.Ref(%s) to table %s that is not in db %s", t.Name(), field, target.Name(), db.Name())
+	}
+	structField, ok := t.Type().FieldByName(field)
+	if !ok || structField.PkgPath != "" {
+		return Ref{}, fmt.Errorf("%s.Ref(%s): %v does not have exported field %s", t.Name(), field, t.Type(), field)
+	}
+	if targetIndex == "" {
+		if structField.Type.Kind() != reflect.String {
+			return Ref{}, fmt.Errorf("%s.Ref(%s) to %s.uid must be a string, not %v", t.Name(), field, target.Name(), structField.Type)
+		}
+	} else {
+		index := target.GetIndex(targetIndex)
+		if index == nil {
+			return Ref{}, fmt.Errorf("%s.Ref(%s): table %s does not have index %s", t.Name(), field, target.Name(), targetIndex)
+		}
+		if len(index.Fields()) != 1 {
+			return Ref{}, fmt.Errorf("%s.Ref(%s): index %s.%s has %d fields instead of 1", t.Name(), field, target.Name(), targetIndex, len(index.Fields()))
+		}
+		targetField, _ := target.Type().FieldByName(index.Fields()[0])
+		if targetField.Type != structField.Type {
+			return Ref{}, fmt.Errorf("%s.Ref(%s) is %v but %s.%s is %v", t.Name(), field, structField.Type, target.Name(), targetField.Name, targetField.Type)
+		}
+	}
+	switch onDel {
+	case RefReject, RefCascade, RefSetNull:
+	default:
+		return Ref{}, fmt.Errorf("%s.Ref(%s) with unknown %v", t.Name(), field, onDel)
+	}
+	return Ref{
+		Table:       t,
+		Field:       field,
+		Target:      target,
+		TargetIndex: targetIndex,
+		OnDel:       onDel,
+	}, nil
+} //newRef()
+
+//CheckRefs returns an error if data written in table t refers to items that do not exist
+//table implementations call it before adding or updating an item
+func CheckRefs(ctx context.Context, t ITable, data IData) error {
+	dataValue := reflect.ValueOf(data)
+	for _, ref := range t.Refs() {
+		value := dataValue.FieldByName(ref.Field)
+		if value.IsZero() {
+			continue
+		}
+		if ref.TargetIndex == "" {
+			_, err := ref.Target.GetItemContext(ctx, value.String())
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrDeleted) {
+				return fmt.Errorf("%s.%s=%s not found in %s", t.Name(), ref.Field, value.String(), ref.Target.Name())
+			}
+			if err != nil {
+				return errors.Wrapf(err, "cannot get %s.%s=%s in %s", t.Name(), ref.Field, value.String(), ref.Target.Name())
+			}
+			continue
+		}
+		index := ref.Target.GetIndex(ref.TargetIndex)
+		found, err := index.FindOneContext(ctx, map[string]interface{}{index.Fields()[0]: value.Interface()})
+		if err != nil {
+			return errors.Wrapf(err, "cannot find %s.%s=%v in %s", t.Name(), ref.Field, value.Interface(), ref.Target.Name())
+		}
+		if found == nil {
+			return fmt.Errorf("%s.%s=%v not found in %s", t.Name(), ref.Field, value.Interface(), ref.Target.Name())
+		}
+	}
+	return nil
+} //CheckRefs()
+
+//Referring is an item that refers to an item that is deleted, or to the old key of an updated item, see DelRefs()
+type Referring struct {
+	Ref Ref
+	UID string
+	//Key is the value of Ref.Field in the item that refers to the deleted item
+	Key interface{}
+}
+
+//DelRefs returns the items that refer to items that are about to be deleted from table t,
+//for table implementations to apply the RefAction with ApplyRefs() after the items are deleted
+//it fails without changing anything if any of the items may not be deleted, also when the RefCascade or RefSetNull
+//of a referring item reaches an item that is referenced with RefReject
+//table implementations call it before deleting items
+func DelRefs(ctx context.Context, t ITable, items ...IItem) ([]Referring, error) {
+	w := refWalk{ctx: ctx, seen: make(map[string]bool), list: make([]Referring, 0)}
+	for _, item := range items {
+		if err := w.removed(t, item, nil, 0); err != nil {
+			return nil, err
+		}
+	}
+	return w.list, nil
+} //DelRefs()
+
+//UpdRefs is DelRefs() for the old keys of items that are about to be updated in table t,
+//when the update changes the TargetIndex field that other items refer to, like deleting the item with the old key
+//cur and upd are the current and next revisions of the items, by position
+//table implementations call it before updating items
+func UpdRefs(ctx context.Context, t ITable, cur []IItem, upd []IItem) ([]Referring, error) {
+	w := refWalk{ctx: ctx, seen: make(map[string]bool), list: make([]Referring, 0)}
+	for n, item := range cur {
+		if err := w.removed(t, item, upd[n].Data(), 0); err != nil {
+			return nil, err
+		}
+	}
+	return w.list, nil
+} //UpdRefs()
+
+//refWalk finds the items that refer to removed keys, and through their RefAction
+//the items that refer to those items, to fail before anything is written when any of them has RefReject
+type refWalk struct {
+	ctx  context.Context
+	seen map[string]bool
+	//list of items that refer directly to the removed keys
+	list []Referring
+}
+
+//removed walks the refs to the keys of item in table t that are removed
+//when the item is deleted (upd is nil) or updated with upd
+func (w *refWalk) removed(t ITable, item IItem, upd IData, depth int) error {
+	for _, ref := range t.RefsTo() {
+		//the value that referring items have in ref.Field
+		var key interface{} = item.UID()
+		if ref.TargetIndex != "" {
+			field := t.GetIndex(ref.TargetIndex).Fields()[0]
+			key = reflect.ValueOf(item.Data()).FieldByName(field).Interface()
+			if upd != nil && reflect.ValueOf(upd).FieldByName(field).Interface() == key {
+				continue
+			}
+		} else if upd != nil {
+			continue //the uid does not change
+		}
+		referringItems, err := ref.Table.ItemsWithContext(w.ctx, ref.Field, key)
+		if err != nil {
+			return errors.Wrapf(err, "cannot get %s items with %s=%v", ref.Table.Name(), ref.Field, key)
+		}
+		for _, i := range referringItems {
+			if ref.OnDel == RefReject {
+				return fmt.Errorf("%s.%s is referenced by %s.%s", t.Name(), item.UID(), ref.Table.Name(), i.UID())
+			}
+			if depth == 0 {
+				w.list = append(w.list, Referring{Ref: ref, UID: i.UID(), Key: key})
+			}
+			seenKey := ref.Table.Name() + "." + i.UID() + "." + ref.Field
+			if w.seen[seenKey] {
+				continue
+			}
+			w.seen[seenKey] = true
+			var next IData
+			if ref.OnDel == RefSetNull {
+				next = clearField(ref.Table, i.Data(), ref.Field)
+			}
+			if err := w.removed(ref.Table, i, next, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+} //refWalk.removed()
+
+//clearField returns a copy of data with the field set to its zero value
+func clearField(t ITable, data IData, field string) IData {
+	dataPtrValue := reflect.New(t.Type())
+	dataPtrValue.Elem().Set(reflect.ValueOf(data))
+	fieldValue := dataPtrValue.Elem().FieldByName(field)
+	fieldValue.Set(reflect.Zero(fieldValue.Type()))
+	return dataPtrValue.Elem().Interface().(IData)
+}
+
+//errRefChanged stops ApplyRefs() from clearing a field that no longer refers to the removed key
+var errRefChanged = errors.New("reference changed")
+
+//ApplyRefs applies the RefAction to the items that referred to deleted items or old keys, see DelRefs() and UpdRefs()
+//items that were deleted or no longer refer to the deleted item in the meantime are skipped
+//table implementations call it after the items were deleted
+func ApplyRefs(ctx context.Context, list []Referring) error {
+	for _, r := range list {
+		item, err := r.Ref.Table.GetItemContext(ctx, r.UID)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrDeleted) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "cannot get referring %s.%s", r.Ref.Table.Name(), r.UID)
+		}
+		if reflect.ValueOf(item.Data()).FieldByName(r.Ref.Field).Interface() != r.Key {
+			continue
+		}
+		switch r.Ref.OnDel {
+		case RefCascade:
+			if err := item.DelContext(ctx); err != nil && !errors.Is(err, ErrDeleted) {
+				return errors.Wrapf(err, "cannot delete referring %s.%s", r.Ref.Table.Name(), r.UID)
+			}
+		case RefSetNull:
+			//modify retries when the item is written in the meantime
+			_, err := r.Ref.Table.ModifyContext(ctx, r.UID, func(current IData) (IData, error) {
+				if reflect.ValueOf(current).FieldByName(r.Ref.Field).Interface() != r.Key {
+					return nil, errRefChanged
+				}
+				return clearField(r.Ref.Table, current, r.Ref.Field), nil
+			})
+			if err != nil && !errors.Is(err, errRefChanged) && !errors.Is(err, ErrDeleted) && !errors.Is(err, ErrNotFound) {
+				return errors.Wrapf(err, "cannot clear referring %s.%s.%s", r.Ref.Table.Name(), r.UID, r.Ref.Field)
+			}
+		}
+	}
+	return nil
+} //ApplyRefs()
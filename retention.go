@@ -0,0 +1,68 @@
+package items
+
+import (
+	"context"
+	"time"
+
+	"github.com/jansemmelink/log"
+)
+
+//Retention policy for the revisions of the items in a table
+//revisions are pruned when they fall outside any of the configured limits,
+//but the latest revision of an item is never pruned
+//the zero value keeps all revisions
+type Retention struct {
+	//KeepRevs keeps the last n revisions of each item (0 for all)
+	KeepRevs int
+	//KeepFor keeps revisions written less than this long ago (0 for all)
+	KeepFor time.Duration
+	//TombstoneOnly keeps only the deleted revision of items that were deleted
+	TombstoneOnly bool
+}
+
+//Drop returns the revision nrs to prune from the revisions of one item, ordered oldest first
+func (r Retention) Drop(revs []IRev, now time.Time) []int {
+	drop := make([]int, 0)
+	if len(revs) < 2 {
+		return drop
+	}
+	latest := revs[len(revs)-1]
+	for i, rev := range revs[:len(revs)-1] {
+		switch {
+		case r.TombstoneOnly && latest.Deleted():
+		case r.KeepRevs > 0 && len(revs)-i > r.KeepRevs:
+		case r.KeepFor > 0 && now.Sub(rev.Timestamp()) > r.KeepFor:
+		default:
+			continue
+		}
+		drop = append(drop, rev.Nr())
+	}
+	return drop
+}
+
+//StartPruner prunes all tables in the db every interval until ctx is done
+//tables without a retention policy are not changed
+func StartPruner(ctx context.Context, db IDb, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for name, t := range db.Tables() {
+					if t.Retention() == (Retention{}) {
+						continue
+					}
+					n, err := t.PruneContext(ctx)
+					if err != nil {
+						log.Errorf("db(%s).table(%s) failed to prune: %v", db.Name(), name, err)
+						continue
+					}
+					log.Debugf("db(%s).table(%s) pruned %d revisions", db.Name(), name, n)
+				}
+			}
+		}
+	}()
+}
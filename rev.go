@@ -1,11 +1,45 @@
 package items
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 //IRev describes a revision ...
 type IRev interface {
 	Nr() int
 	Timestamp() time.Time
+	//Deleted is true when this revision marks the item as deleted
+	Deleted() bool
+
+	//Actor who wrote this revision, "" if not known
+	Actor() string
+	//Reason for writing this revision, "" if not specified
+	Reason() string
+	//Annotations of this revision, nil if none
+	Annotations() map[string]string
+
+	//ValidFrom and ValidTo is the valid time of this revision, when its data is true in the real world,
+	//as opposed to its Timestamp(), when it was recorded
+	//the range includes ValidFrom but not ValidTo, and a zero time means it is not bounded on that side
+	ValidFrom() time.Time
+	ValidTo() time.Time
+	//ValidAt is true when t is in the valid time of this revision
+	ValidAt(t time.Time) bool
+
+	//Expires is when the item expires at this revision, zero if not set, see WithExpiry() and TTL
+	Expires() time.Time
+}
+
+//RevInfo describes who wrote a revision, why, and when it is valid
+//it is written with the revision when it is in the context of the write, see WithActor() etc.
+type RevInfo struct {
+	Actor       string
+	Reason      string
+	Annotations map[string]string
+	ValidFrom   time.Time
+	ValidTo     time.Time
+	Expires     time.Time
 }
 
 //Rev info
@@ -13,9 +47,33 @@ func Rev(nr int, ts time.Time) IRev {
 	return rev{nr: nr, ts: ts}
 }
 
+//DeletedRev info for the revision that deleted an item
+func DeletedRev(nr int, ts time.Time) IRev {
+	return rev{nr: nr, ts: ts, deleted: true}
+}
+
+//NewRev with all the revision details
+func NewRev(nr int, ts time.Time, deleted bool, info RevInfo) IRev {
+	return rev{nr: nr, ts: ts, deleted: deleted, info: info.copy()}
+}
+
+//RevInfoOf returns the info of a revision
+func RevInfoOf(r IRev) RevInfo {
+	return RevInfo{
+		Actor:       r.Actor(),
+		Reason:      r.Reason(),
+		Annotations: r.Annotations(),
+		ValidFrom:   r.ValidFrom(),
+		ValidTo:     r.ValidTo(),
+		Expires:     r.Expires(),
+	}
+}
+
 type rev struct {
-	nr int
-	ts time.Time
+	nr      int
+	ts      time.Time
+	deleted bool
+	info    RevInfo
 }
 
 func (r rev) Nr() int {
@@ -25,3 +83,116 @@ func (r rev) Nr() int {
 func (r rev) Timestamp() time.Time {
 	return r.ts
 }
+
+func (r rev) Deleted() bool {
+	return r.deleted
+}
+
+func (r rev) Actor() string {
+	return r.info.Actor
+}
+
+func (r rev) Reason() string {
+	return r.info.Reason
+}
+
+func (r rev) Annotations() map[string]string {
+	return r.info.copy().Annotations
+}
+
+func (r rev) ValidFrom() time.Time {
+	return r.info.ValidFrom
+}
+
+func (r rev) ValidTo() time.Time {
+	return r.info.ValidTo
+}
+
+func (r rev) ValidAt(t time.Time) bool {
+	if !r.info.ValidFrom.IsZero() && t.Before(r.info.ValidFrom) {
+		return false
+	}
+	if !r.info.ValidTo.IsZero() && !t.Before(r.info.ValidTo) {
+		return false
+	}
+	return true
+}
+
+func (r rev) Expires() time.Time {
+	return r.info.Expires
+}
+
+func (info RevInfo) copy() RevInfo {
+	if len(info.Annotations) == 0 {
+		info.Annotations = nil
+		return info
+	}
+	annotations := make(map[string]string, len(info.Annotations))
+	for k, v := range info.Annotations {
+		annotations[k] = v
+	}
+	info.Annotations = annotations
+	return info
+}
+
+type revInfoKey struct{}
+
+//WithActor returns a context to write revisions by the actor, e.g. the authenticated user
+func WithActor(ctx context.Context, actor string) context.Context {
+	info := RevInfoFrom(ctx)
+	info.Actor = actor
+	return context.WithValue(ctx, revInfoKey{}, info)
+}
+
+//WithReason returns a context to write revisions for the reason
+func WithReason(ctx context.Context, reason string) context.Context {
+	info := RevInfoFrom(ctx)
+	info.Reason = reason
+	return context.WithValue(ctx, revInfoKey{}, info)
+}
+
+//WithAnnotation returns a context to write revisions with an annotation,
+//in addition to annotations already in the context
+func WithAnnotation(ctx context.Context, key string, value string) context.Context {
+	info := RevInfoFrom(ctx)
+	if info.Annotations == nil {
+		info.Annotations = make(map[string]string)
+	}
+	info.Annotations[key] = value
+	return context.WithValue(ctx, revInfoKey{}, info)
+}
+
+//WithValidTime returns a context to write revisions that are valid from (inclusive) until to (exclusive),
+//with a zero time for a range that is not bounded on that side
+//revisions written without it are always valid, see IRev.ValidAt()
+func WithValidTime(ctx context.Context, from time.Time, to time.Time) context.Context {
+	info := RevInfoFrom(ctx)
+	info.ValidFrom = from
+	info.ValidTo = to
+	return context.WithValue(ctx, revInfoKey{}, info)
+}
+
+//WithExpiry returns a context to write revisions of items that expire at the specified time,
+//instead of after the time-to-live of the table, see TTL
+func WithExpiry(ctx context.Context, at time.Time) context.Context {
+	info := RevInfoFrom(ctx)
+	info.Expires = at
+	return context.WithValue(ctx, revInfoKey{}, info)
+}
+
+//RevInfoFrom returns the revision info in the context
+func RevInfoFrom(ctx context.Context) RevInfo {
+	info, _ := ctx.Value(revInfoKey{}).(RevInfo)
+	return info.copy()
+}
+
+//ApplyRevInfo returns the item with the revision info in the context
+//table implementations call it for each revision they are about to write
+func ApplyRevInfo(ctx context.Context, i IItem) IItem {
+	r := i.Rev()
+	next := NewItem(i.Table(), i.NID(), i.UID(), NewRev(r.Nr(), r.Timestamp(), r.Deleted(), RevInfoFrom(ctx)), i.Data())
+	if r.Nr() > 1 {
+		next = WithCreated(next, i.Created())
+	}
+	return next
+}
@@ -0,0 +1,39 @@
+package items
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+func (t *table) Revert(uid string, revNr int) (IItem, error) {
+	return t.RevertContext(context.Background(), uid, revNr)
+}
+
+func (t *table) RevertContext(ctx context.Context, uid string, revNr int) (IItem, error) {
+	//use the table as registered in the db, which wraps this table
+	self := t.db.GetTable(t.name)
+	if self == nil {
+		return nil, fmt.Errorf("db(%s).table(%s) not found", t.db.Name(), t.name)
+	}
+	cur, err := self.GetItemContext(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	revs, err := self.HistoryContext(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		if rev.Rev().Nr() != revNr {
+			continue
+		}
+		if rev.Rev().Deleted() {
+			return nil, fmt.Errorf("%s.%s cannot revert to deleted rev %d", t.name, uid, revNr)
+		}
+		//the update fails if cur is no longer the latest revision
+		return cur.UpdContext(ctx, rev.Data())
+	}
+	return nil, errors.Wrapf(ErrNotFound, "%s.%s rev %d", t.name, uid, revNr)
+} //table.RevertContext()
@@ -0,0 +1,107 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//ScheduledRev is the next revision of an item that is written at a later time, see ITable.Schedule()
+type ScheduledRev struct {
+	ID  int
+	UID string
+	NID int
+	//RevNr is the revision nr it is written as, so the item must still be at RevNr-1 when it is due
+	RevNr int
+	//At is when it is written, and the timestamp of the revision
+	At   time.Time
+	Data IData
+	//Info is written with the revision, from the context in which it was scheduled, see WithActor() etc.
+	Info RevInfo
+	//Failure is the error when it was due but could not be written, "" while it is pending
+	Failure string
+}
+
+//NewScheduledRev checks the next revision of an item from NextItem() to write at a later time
+//and returns it to schedule with the revision info in the context
+func NewScheduledRev(ctx context.Context, t ITable, upd IItem, at time.Time) (ScheduledRev, error) {
+	if upd != nil && !at.After(t.Db().Now()) {
+		return ScheduledRev{}, fmt.Errorf("%s.Schedule(%d,%s) at %v is not in the future", t.Name(), upd.NID(), upd.UID(), at)
+	}
+	cur, err := checkNext(ctx, t, upd, "Schedule")
+	if err != nil {
+		return ScheduledRev{}, err
+	}
+	return ScheduledRev{
+		UID:   cur.UID(),
+		NID:   cur.NID(),
+		RevNr: upd.Rev().Nr(),
+		At:    at,
+		Data:  upd.Data(),
+		Info:  RevInfoFrom(ctx),
+	}, nil
+} //NewScheduledRev()
+
+//checkNext checks that upd is the next revision of the current item in the table, with valid data,
+//before it is written later by op, and returns the current revision
+func checkNext(ctx context.Context, t ITable, upd IItem, op string) (IItem, error) {
+	if upd == nil {
+		return nil, fmt.Errorf("%s.%s(nil)", t.Name(), op)
+	}
+	if upd.Table() != t {
+		return nil, fmt.Errorf("%s.%s(%d,%s) from other table(%s)", t.Name(), op, upd.NID(), upd.UID(), upd.Table().Name())
+	}
+	cur, err := t.GetItemContext(ctx, upd.UID())
+	if err != nil {
+		return nil, err
+	}
+	if upd.Rev().Nr() != cur.Rev().Nr()+1 {
+		return nil, &ErrRevisionConflict{Table: t.Name(), UID: upd.UID(), Expected: upd.Rev().Nr() - 1, Actual: cur.Rev().Nr()}
+	}
+	if err := upd.Data().Validate(); err != nil {
+		return nil, &ErrInvalidData{Table: t.Name(), Err: err}
+	}
+	return cur, nil
+} //checkNext()
+
+//Due is true when the scheduled revision is pending and its time has come
+func (s ScheduledRev) Due(now time.Time) bool {
+	return s.Failure == "" && !s.At.After(now)
+}
+
+//Write the scheduled revision to the table like UpdItem(), with its revision info instead of the info in the context
+//table implementations call it when it is due
+func (s ScheduledRev) Write(ctx context.Context, t ITable) (IItem, error) {
+	ctx, upd := s.Next(ctx, t)
+	return t.UpdItemContext(ctx, upd)
+}
+
+//Next returns the scheduled revision as the next revision of the item,
+//with a context that has its revision info instead of the info in ctx, to write like Write()
+func (s ScheduledRev) Next(ctx context.Context, t ITable) (context.Context, IItem) {
+	return context.WithValue(ctx, revInfoKey{}, s.Info.copy()), NewItem(t, s.NID, s.UID, NewRev(s.RevNr, s.At, false, s.Info), s.Data)
+}
+
+func (t *table) Schedule(upd IItem, at time.Time) (ScheduledRev, error) {
+	return t.ScheduleContext(context.Background(), upd, at)
+}
+
+func (t *table) ScheduleContext(ctx context.Context, upd IItem, at time.Time) (ScheduledRev, error) {
+	return ScheduledRev{}, fmt.Errorf("db(%s).table(%s).Schedule() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) Scheduled() ([]ScheduledRev, error) {
+	return t.ScheduledContext(context.Background())
+}
+
+func (t *table) ScheduledContext(ctx context.Context) ([]ScheduledRev, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).Scheduled() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) CancelScheduled(id int) error {
+	return t.CancelScheduledContext(context.Background(), id)
+}
+
+func (t *table) CancelScheduledContext(ctx context.Context, id int) error {
+	return fmt.Errorf("db(%s).table(%s).CancelScheduled() not implemented", t.db.Name(), t.name)
+}
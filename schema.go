@@ -1,15 +1,35 @@
 package items
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
+
+	"github.com/pkg/errors"
 )
 
 //ISchema is create from IData to iterate over fields and sub-structures
-type ISchema interface{}
+type ISchema interface {
+	//Type of the struct
+	Type() reflect.Type
+	//Fields lists the names of the exported fields, as StructFields()
+	Fields() []string
+	//Field returns the exported field with the specified name
+	Field(name string) (reflect.StructField, bool)
+}
 
 //NewSchema from a reflect structure type
 func NewSchema(t reflect.Type) (ISchema, error) {
+	if t == nil {
+		return nil, fmt.Errorf("NewSchema(nil)")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%v is not a struct", t)
+	}
 	return schema{t}, nil
 }
 
@@ -17,6 +37,128 @@ type schema struct {
 	t reflect.Type
 }
 
+func (s schema) Type() reflect.Type {
+	return s.t
+}
+
+func (s schema) Fields() []string {
+	list := make([]string, 0, s.t.NumField())
+	for fieldIndex := 0; fieldIndex < s.t.NumField(); fieldIndex++ {
+		if structField := s.t.Field(fieldIndex); structField.PkgPath == "" {
+			list = append(list, structField.Name)
+		}
+	}
+	return list
+}
+
+func (s schema) Field(name string) (reflect.StructField, bool) {
+	structField, ok := s.t.FieldByName(name)
+	if !ok || structField.PkgPath != "" || len(structField.Index) != 1 {
+		return reflect.StructField{}, false
+	}
+	return structField, true
+}
+
+//ConvertValue converts a value to the specified type, e.g. to set a struct field
+//the value may be assignable or convertible without loss to the type,
+//a string that is parsed like a URL query value, or anything that JSON encodes to the type,
+//like the map[string]interface{} that JSON decoded from an object into a struct
+//a nil value converts to the zero value of the type
+func ConvertValue(value interface{}, t reflect.Type) (interface{}, error) {
+	if value == nil {
+		return reflect.Zero(t).Interface(), nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(t) {
+		return value, nil
+	}
+	if isNumber(v.Kind()) && isNumber(t.Kind()) {
+		//e.g. float64 from JSON into int, if it is a whole number in range
+		//a negative value wraps around in an unsigned type and back, so the sign is checked too
+		converted := v.Convert(t)
+		if converted.Convert(v.Type()).Interface() != value || negative(v) != negative(converted) {
+			return nil, fmt.Errorf("cannot convert %v to %v without loss", value, t)
+		}
+		return converted.Interface(), nil
+	}
+	if s, ok := value.(string); ok {
+		return parseValue(s, t)
+	}
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot convert %T to %v", value, t)
+	}
+	ptrValue := reflect.New(t)
+	if err := json.Unmarshal(jsonValue, ptrValue.Interface()); err != nil {
+		return nil, errors.Wrapf(err, "cannot convert %T to %v", value, t)
+	}
+	return ptrValue.Elem().Interface(), nil
+} //ConvertValue()
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+//negative is true for a number below zero
+func negative(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() < 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() < 0
+	}
+	return false
+}
+
+//parseValue converts a string to the type
+func parseValue(s string, t reflect.Type) (interface{}, error) {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		v.SetBool(b)
+	default:
+		//e.g. time.Time or a struct from JSON, where a string must be quoted
+		jsonValue := []byte(s)
+		if !json.Valid(jsonValue) {
+			jsonValue, _ = json.Marshal(s)
+		}
+		if err := json.Unmarshal(jsonValue, v.Addr().Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return v.Interface(), nil
+} //parseValue()
+
 //StructFields list the exported fields of the struct in CSV e.g. "Name,Surname"
 func StructFields(t reflect.Type) string {
 	//dereference to struct level
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jansemmelink/items"
+	"github.com/jansemmelink/log"
+	"github.com/pkg/errors"
+)
+
+//IServer is an HTTP handler that exposes the registered tables of an items.IDb as REST/JSON:
+//	GET    /tables                                  list of registered table names
+//	GET    /tables/{name}                           table description
+//	GET    /tables/{name}/items                     all items, or ?index={index}&{field}={value}... to find by index
+//	POST   /tables/{name}/items                     add an item
+//	GET    /tables/{name}/items/{uid}               get the latest revision of an item
+//	PUT    /tables/{name}/items/{uid}               update an item (If-Match required)
+//	PATCH  /tables/{name}/items/{uid}               update some fields of an item (If-Match required)
+//	DELETE /tables/{name}/items/{uid}               delete an item (If-Match required)
+//	GET    /tables/{name}/items/{uid}/history       all revisions of an item
+//	GET    /tables/{name}/items/{uid}/history/{rev} a specific revision of an item
+//The revision nr of an item is used as its ETag
+type IServer interface {
+	http.Handler
+
+	//Register makes the named table available over HTTP
+	//the table is created in the db if it does not exist yet
+	Register(name string, tmplStruct items.IData) (items.ITable, error)
+}
+
+//New server for the specified database
+func New(db items.IDb) IServer {
+	if db == nil {
+		panic("server.New(db==nil)")
+	}
+	return &server{
+		db:     db,
+		tables: make(map[string]items.ITable),
+	}
+}
+
+type server struct {
+	db     items.IDb
+	mutex  sync.Mutex
+	tables map[string]items.ITable
+}
+
+func (s *server) Register(name string, tmplStruct items.IData) (items.ITable, error) {
+	if tmplStruct == nil {
+		return nil, fmt.Errorf("server.Register(%s,nil)", name)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.tables[name]; ok {
+		return nil, fmt.Errorf("table(%s) already registered", name)
+	}
+
+	t := s.db.GetTable(name)
+	if t == nil {
+		var err error
+		t, err = s.db.Table(name, tmplStruct)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create table(%s)", name)
+		}
+	} else if t.Type() != reflect.TypeOf(tmplStruct) {
+		return nil, fmt.Errorf("table(%s) stores %v, not %T", name, t.Type(), tmplStruct)
+	}
+
+	s.tables[name] = t
+	return t, nil
+}
+
+func (s *server) table(name string) items.ITable {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, _ := s.tables[name]
+	return t
+}
+
+func (s *server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	log.Debugf("HTTP %s %s", req.Method, req.URL.Path)
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 1 || parts[0] != "tables" {
+		errorResponse(res, http.StatusNotFound, fmt.Errorf("unknown path %s", req.URL.Path))
+		return
+	}
+	if len(parts) == 1 {
+		if req.Method != http.MethodGet {
+			methodNotAllowed(res, req)
+			return
+		}
+		s.listTables(res)
+		return
+	}
+
+	t := s.table(parts[1])
+	if t == nil {
+		errorResponse(res, http.StatusNotFound, fmt.Errorf("unknown table %s", parts[1]))
+		return
+	}
+
+	switch {
+	case len(parts) == 2:
+		switch req.Method {
+		case http.MethodGet:
+			s.getTable(res, req, t)
+		default:
+			methodNotAllowed(res, req)
+		}
+	case len(parts) == 3 && parts[2] == "items":
+		switch req.Method {
+		case http.MethodGet:
+			s.getItems(res, req, t)
+		case http.MethodPost:
+			s.addItem(res, req, t)
+		default:
+			methodNotAllowed(res, req)
+		}
+	case len(parts) == 4 && parts[2] == "items":
+		switch req.Method {
+		case http.MethodGet:
+			s.getItem(res, req, t, parts[3])
+		case http.MethodPut:
+			s.updItem(res, req, t, parts[3])
+		case http.MethodPatch:
+			s.patchItem(res, req, t, parts[3])
+		case http.MethodDelete:
+			s.delItem(res, req, t, parts[3])
+		default:
+			methodNotAllowed(res, req)
+		}
+	case len(parts) == 5 && parts[2] == "items" && parts[4] == "history":
+		switch req.Method {
+		case http.MethodGet:
+			s.getHistory(res, req, t, parts[3])
+		default:
+			methodNotAllowed(res, req)
+		}
+	case len(parts) == 6 && parts[2] == "items" && parts[4] == "history":
+		switch req.Method {
+		case http.MethodGet:
+			s.getRevision(res, req, t, parts[3], parts[5])
+		default:
+			methodNotAllowed(res, req)
+		}
+	default:
+		errorResponse(res, http.StatusNotFound, fmt.Errorf("unknown path %s", req.URL.Path))
+	}
+} //server.ServeHTTP()
+
+func (s *server) listTables(res http.ResponseWriter) {
+	s.mutex.Lock()
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	s.mutex.Unlock()
+
+	sort.Strings(names)
+	jsonResponse(res, http.StatusOK, names)
+}
+
+func (s *server) getTable(res http.ResponseWriter, req *http.Request, t items.ITable) {
+	count, err := t.CountContext(req.Context())
+	if err != nil {
+		errorResponse(res, http.StatusInternalServerError, err)
+		return
+	}
+	fields := items.StructFields(t.Type())
+	jsonResponse(res, http.StatusOK, jsonTable{
+		Name:   t.Name(),
+		Fields: strings.Split(fields, ","),
+		Count:  count,
+	})
+}
+
+//getItems returns all items, or the items found with an index
+//when the index name is specified in the URL query
+func (s *server) getItems(res http.ResponseWriter, req *http.Request, t items.ITable) {
+	query := req.URL.Query()
+	indexName := query.Get("index")
+	if indexName == "" {
+		all, err := t.ItemsContext(req.Context())
+		if err != nil {
+			errorResponse(res, http.StatusInternalServerError, err)
+			return
+		}
+		list := make([]items.IItem, 0, len(all))
+		for _, item := range all {
+			list = append(list, item)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].NID() < list[j].NID() })
+		itemsResponse(res, list)
+		return
+	}
+
+	index := t.GetIndex(indexName)
+	if index == nil {
+		errorResponse(res, http.StatusBadRequest, fmt.Errorf("table %s does not have index %s", t.Name(), indexName))
+		return
+	}
+	key := make(map[string]interface{})
+	for _, fieldName := range index.Fields() {
+		if _, ok := query[fieldName]; !ok {
+			errorResponse(res, http.StatusBadRequest, fmt.Errorf("missing index %s field %s", indexName, fieldName))
+			return
+		}
+		structField, _ := t.Type().FieldByName(fieldName)
+		value, err := items.ConvertValue(query.Get(fieldName), structField.Type)
+		if err != nil {
+			errorResponse(res, http.StatusBadRequest, errors.Wrapf(err, "invalid value for %s", fieldName))
+			return
+		}
+		key[fieldName] = value
+	}
+
+	item, err := index.FindOneContext(req.Context(), key)
+	if err != nil {
+		errorResponse(res, http.StatusInternalServerError, err)
+		return
+	}
+	list := make([]items.IItem, 0)
+	if item != nil {
+		list = append(list, item)
+	}
+	itemsResponse(res, list)
+} //server.getItems()
+
+func (s *server) addItem(res http.ResponseWriter, req *http.Request, t items.ITable) {
+	data, err := decodeData(req, t)
+	if err != nil {
+		errorResponse(res, http.StatusBadRequest, err)
+		return
+	}
+	newItem, err := t.AddItemContext(req.Context(), data)
+	if err != nil {
+		errorResponse(res, errorStatus(err), err)
+		return
+	}
+	res.Header().Set("Location", fmt.Sprintf("/tables/%s/items/%s", t.Name(), newItem.UID()))
+	itemResponse(res, http.StatusCreated, newItem)
+}
+
+func (s *server) getItem(res http.ResponseWriter, req *http.Request, t items.ITable, uid string) {
+	item, err := t.GetItemContext(req.Context(), uid)
+	if err != nil {
+		errorResponse(res, errorStatus(err), err)
+		return
+	}
+	if noneMatch := req.Header.Get("If-None-Match"); noneMatch != "" && noneMatch == etag(item) {
+		res.Header().Set("ETag", etag(item))
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+	itemResponse(res, http.StatusOK, item)
+}
+
+func (s *server) updItem(res http.ResponseWriter, req *http.Request, t items.ITable, uid string) {
+	cur, ok := s.matchItem(res, req, t, uid)
+	if !ok {
+		return
+	}
+	data, err := decodeData(req, t)
+	if err != nil {
+		errorResponse(res, http.StatusBadRequest, err)
+		return
+	}
+	updItem, err := cur.UpdContext(req.Context(), data)
+	if err != nil {
+		errorResponse(res, errorStatus(err), err)
+		return
+	}
+	itemResponse(res, http.StatusOK, updItem)
+}
+
+//patchItem updates the fields in the JSON object in the request body
+func (s *server) patchItem(res http.ResponseWriter, req *http.Request, t items.ITable, uid string) {
+	cur, ok := s.matchItem(res, req, t, uid)
+	if !ok {
+		return
+	}
+	fields := make(map[string]interface{})
+	decoder := json.NewDecoder(req.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		errorResponse(res, http.StatusBadRequest, errors.Wrapf(err, "cannot parse fields from JSON body"))
+		return
+	}
+	updItem, err := t.PatchContext(req.Context(), cur, fields)
+	if err != nil {
+		errorResponse(res, errorStatus(err), err)
+		return
+	}
+	itemResponse(res, http.StatusOK, updItem)
+}
+
+func (s *server) delItem(res http.ResponseWriter, req *http.Request, t items.ITable, uid string) {
+	cur, ok := s.matchItem(res, req, t, uid)
+	if !ok {
+		return
+	}
+	if err := cur.DelContext(req.Context()); err != nil {
+		errorResponse(res, errorStatus(err), err)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+//matchItem gets the current revision of the item and checks that
+//it matches the If-Match header of the request
+//it writes the error response and return ok=false when it does not
+func (s *server) matchItem(res http.ResponseWriter, req *http.Request, t items.ITable, uid string) (items.IItem, bool) {
+	ifMatch := strings.Join(req.Header.Values("If-Match"), ",")
+	if ifMatch == "" {
+		errorResponse(res, http.StatusPreconditionRequired, fmt.Errorf("missing If-Match header"))
+		return nil, false
+	}
+	cur, err := t.GetItemContext(req.Context(), uid)
+	if err != nil {
+		errorResponse(res, errorStatus(err), err)
+		return nil, false
+	}
+	if !matchETag(ifMatch, etag(cur)) {
+		res.Header().Set("ETag", etag(cur))
+		errorResponse(res, http.StatusPreconditionFailed, fmt.Errorf("%s.%s is at revision %d", t.Name(), uid, cur.Rev().Nr()))
+		return nil, false
+	}
+	return cur, true
+}
+
+//matchETag is true when the If-Match header is "*" or lists tag
+//weak tags (W/"3") never match, because If-Match uses strong comparison (RFC 7232 section 3.1)
+func matchETag(ifMatch string, tag string) bool {
+	for _, t := range strings.Split(ifMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+//errorStatus is the HTTP status for an error returned by the table
+func errorStatus(err error) int {
+	var conflict *items.ErrRevisionConflict
+	var duplicate *items.ErrDuplicateKey
+	var invalid *items.ErrInvalidData
+	switch {
+	case errors.Is(err, items.ErrNotFound), errors.Is(err, items.ErrDeleted):
+		return http.StatusNotFound
+	case errors.As(err, &conflict):
+		//the item changed after the If-Match was checked
+		return http.StatusPreconditionFailed
+	case errors.As(err, &duplicate):
+		return http.StatusConflict
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *server) getHistory(res http.ResponseWriter, req *http.Request, t items.ITable, uid string) {
+	revs, err := t.HistoryContext(req.Context(), uid)
+	if err != nil {
+		errorResponse(res, errorStatus(err), err)
+		return
+	}
+	itemsResponse(res, revs)
+}
+
+func (s *server) getRevision(res http.ResponseWriter, req *http.Request, t items.ITable, uid string, revStr string) {
+	revNr, err := strconv.Atoi(revStr)
+	if err != nil {
+		errorResponse(res, http.StatusBadRequest, fmt.Errorf("invalid revision nr \"%s\"", revStr))
+		return
+	}
+	revs, err := t.HistoryContext(req.Context(), uid)
+	if err != nil {
+		errorResponse(res, errorStatus(err), err)
+		return
+	}
+	for _, rev := range revs {
+		if rev.Rev().Nr() == revNr {
+			itemResponse(res, http.StatusOK, rev)
+			return
+		}
+	}
+	errorResponse(res, http.StatusNotFound, fmt.Errorf("%s.%s revision %d not found", t.Name(), uid, revNr))
+}
+
+//decodeData parses the JSON request body into the table's struct type
+func decodeData(req *http.Request, t items.ITable) (items.IData, error) {
+	dataPtrValue := reflect.New(t.Type())
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dataPtrValue.Interface()); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %v from JSON body", t.Type())
+	}
+	data, ok := dataPtrValue.Elem().Interface().(items.IData)
+	if !ok {
+		return nil, fmt.Errorf("%v is not IData", t.Type())
+	}
+	if err := data.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid %v", t.Type())
+	}
+	return data, nil
+}
+
+func etag(item items.IItem) string {
+	return fmt.Sprintf("\"%d\"", item.Rev().Nr())
+}
+
+type jsonTable struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+	Count  int      `json:"count"`
+}
+
+type jsonItem struct {
+	NID       int          `json:"nid"`
+	UID       string       `json:"uid"`
+	Rev       jsonRev      `json:"rev"`
+	Created   *jsonCreated `json:"created,omitempty"`
+	Revisions int          `json:"revisions"`
+	Data      items.IData  `json:"data"`
+}
+
+type jsonCreated struct {
+	Timestamp time.Time `json:"ts"`
+	Actor     string    `json:"actor,omitempty"`
+}
+
+type jsonRev struct {
+	Nr          int               `json:"nr"`
+	Timestamp   time.Time         `json:"ts"`
+	Deleted     bool              `json:"deleted,omitempty"`
+	Actor       string            `json:"actor,omitempty"`
+	Reason      string            `json:"reason,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	ValidFrom   *time.Time        `json:"validFrom,omitempty"`
+	ValidTo     *time.Time        `json:"validTo,omitempty"`
+}
+
+func newJSONItem(item items.IItem) jsonItem {
+	j := jsonItem{
+		NID: item.NID(),
+		UID: item.UID(),
+		Rev: jsonRev{
+			Nr:          item.Rev().Nr(),
+			Timestamp:   item.Rev().Timestamp(),
+			Deleted:     item.Rev().Deleted(),
+			Actor:       item.Rev().Actor(),
+			Reason:      item.Rev().Reason(),
+			Annotations: item.Rev().Annotations(),
+		},
+		Revisions: item.Revisions(),
+		Data:      item.Data(),
+	}
+	if validFrom := item.Rev().ValidFrom(); !validFrom.IsZero() {
+		j.Rev.ValidFrom = &validFrom
+	}
+	if validTo := item.Rev().ValidTo(); !validTo.IsZero() {
+		j.Rev.ValidTo = &validTo
+	}
+	if created := item.Created(); created != nil {
+		j.Created = &jsonCreated{Timestamp: created.Timestamp(), Actor: created.Actor()}
+	}
+	return j
+}
+
+func itemResponse(res http.ResponseWriter, status int, item items.IItem) {
+	res.Header().Set("ETag", etag(item))
+	jsonResponse(res, status, newJSONItem(item))
+}
+
+func itemsResponse(res http.ResponseWriter, list []items.IItem) {
+	jsonList := make([]jsonItem, 0, len(list))
+	for _, item := range list {
+		jsonList = append(jsonList, newJSONItem(item))
+	}
+	jsonResponse(res, http.StatusOK, jsonList)
+}
+
+func methodNotAllowed(res http.ResponseWriter, req *http.Request) {
+	errorResponse(res, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on %s", req.Method, req.URL.Path))
+}
+
+func errorResponse(res http.ResponseWriter, status int, err error) {
+	log.Debugf("HTTP %d: %v", status, err)
+	jsonResponse(res, status, map[string]string{"error": err.Error()})
+}
+
+func jsonResponse(res http.ResponseWriter, status int, body interface{}) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("failed to encode %T as JSON: %v", body, err)
+		http.Error(res, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	res.Write(jsonBody)
+}
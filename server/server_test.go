@@ -0,0 +1,170 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jansemmelink/items/mem"
+	"github.com/jansemmelink/items/server"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func (u user) Validate() error {
+	if len(u.Name) < 1 {
+		return fmt.Errorf("missing user.name")
+	}
+	return nil
+}
+
+type result struct {
+	NID  int    `json:"nid"`
+	UID  string `json:"uid"`
+	Data user   `json:"data"`
+	Rev  struct {
+		Nr      int  `json:"nr"`
+		Deleted bool `json:"deleted"`
+	} `json:"rev"`
+}
+
+func TestServer(t *testing.T) {
+	db, err := mem.New("store")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	s := server.New(db)
+	users, err := s.Register("users", user{})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if _, err := users.Index("username", []string{"Name"}); err != nil {
+		t.Fatalf("Failed to add index: %v", err)
+	}
+	if _, err := s.Register("users", user{}); err == nil {
+		t.Fatalf("Registered users twice")
+	}
+
+	//add
+	res := do(s, http.MethodPost, "/tables/users/items", "", `{"Name":"one","Age":1}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", res.Code, res.Body.String())
+	}
+	var added result
+	decode(t, res, &added)
+	if added.Rev.Nr != 1 || added.Data.Name != "one" || res.Header().Get("ETag") != `"1"` {
+		t.Fatalf("POST: %+v etag=%s", added, res.Header().Get("ETag"))
+	}
+	itemPath := "/tables/users/items/" + added.UID
+	if res.Header().Get("Location") != itemPath {
+		t.Fatalf("POST: location=%s", res.Header().Get("Location"))
+	}
+	if res := do(s, http.MethodPost, "/tables/users/items", "", `{"Age":1}`); res.Code != http.StatusBadRequest {
+		t.Fatalf("POST invalid: %d %s", res.Code, res.Body.String())
+	}
+	if res := do(s, http.MethodPost, "/tables/users/items", "", `{"Name":"one","Age":2}`); res.Code != http.StatusConflict {
+		t.Fatalf("POST duplicate: %d %s", res.Code, res.Body.String())
+	}
+
+	//get
+	res = do(s, http.MethodGet, itemPath, "", "")
+	if res.Code != http.StatusOK || res.Header().Get("ETag") != `"1"` {
+		t.Fatalf("GET: %d %s", res.Code, res.Body.String())
+	}
+	if res := do(s, http.MethodGet, "/tables/users/items/unknown", "", ""); res.Code != http.StatusNotFound {
+		t.Fatalf("GET unknown: %d", res.Code)
+	}
+
+	//find by index
+	res = do(s, http.MethodGet, "/tables/users/items?index=username&Name=one", "", "")
+	var found []result
+	decode(t, res, &found)
+	if res.Code != http.StatusOK || len(found) != 1 || found[0].UID != added.UID {
+		t.Fatalf("GET by index: %d %s", res.Code, res.Body.String())
+	}
+
+	//update requires If-Match with the current revision
+	if res := do(s, http.MethodPut, itemPath, "", `{"Name":"ONE","Age":2}`); res.Code != http.StatusPreconditionRequired {
+		t.Fatalf("PUT without If-Match: %d", res.Code)
+	}
+	res = do(s, http.MethodPut, itemPath, `"1"`, `{"Name":"ONE","Age":2}`)
+	if res.Code != http.StatusOK || res.Header().Get("ETag") != `"2"` {
+		t.Fatalf("PUT: %d %s", res.Code, res.Body.String())
+	}
+	if res := do(s, http.MethodPut, itemPath, `"1"`, `{"Name":"ONEONE","Age":3}`); res.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with old rev: %d", res.Code)
+	}
+
+	//patch some fields
+	res = do(s, http.MethodPatch, itemPath, `"2"`, `{"Age":7}`)
+	var patched result
+	decode(t, res, &patched)
+	if res.Code != http.StatusOK || res.Header().Get("ETag") != `"3"` || patched.Data != (user{Name: "ONE", Age: 7}) {
+		t.Fatalf("PATCH: %d %s", res.Code, res.Body.String())
+	}
+	if res := do(s, http.MethodPatch, itemPath, `"3"`, `{"Unknown":7}`); res.Code != http.StatusBadRequest {
+		t.Fatalf("PATCH unknown field: %d %s", res.Code, res.Body.String())
+	}
+
+	//If-Match may have a list of tags, which must match with strong comparison
+	if res := do(s, http.MethodPatch, itemPath, `"1", "2"`, `{"Age":8}`); res.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PATCH with list of old revs: %d", res.Code)
+	}
+	if res := do(s, http.MethodPatch, itemPath, `W/"3"`, `{"Age":8}`); res.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PATCH with weak tag: %d", res.Code)
+	}
+	if res := do(s, http.MethodPatch, itemPath, `"1", "3"`, `{"Age":8}`); res.Code != http.StatusOK || res.Header().Get("ETag") != `"4"` {
+		t.Fatalf("PATCH with tag in list: %d %s", res.Code, res.Body.String())
+	}
+
+	//delete
+	if res := do(s, http.MethodDelete, itemPath, `"1"`, ""); res.Code != http.StatusPreconditionFailed {
+		t.Fatalf("DELETE with old rev: %d", res.Code)
+	}
+	if res := do(s, http.MethodDelete, itemPath, `"4"`, ""); res.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: %d %s", res.Code, res.Body.String())
+	}
+	if res := do(s, http.MethodGet, itemPath, "", ""); res.Code != http.StatusNotFound {
+		t.Fatalf("GET after DELETE: %d", res.Code)
+	}
+
+	//history
+	res = do(s, http.MethodGet, itemPath+"/history", "", "")
+	var history []result
+	decode(t, res, &history)
+	if res.Code != http.StatusOK || len(history) != 5 || !history[4].Rev.Deleted {
+		t.Fatalf("GET history: %d %s", res.Code, res.Body.String())
+	}
+	res = do(s, http.MethodGet, itemPath+"/history/1", "", "")
+	var rev1 result
+	decode(t, res, &rev1)
+	if res.Code != http.StatusOK || rev1.Rev.Nr != 1 || rev1.Data.Name != "one" {
+		t.Fatalf("GET rev 1: %d %s", res.Code, res.Body.String())
+	}
+
+	if res := do(s, http.MethodGet, "/tables/other/items", "", ""); res.Code != http.StatusNotFound {
+		t.Fatalf("GET unknown table: %d", res.Code)
+	}
+}
+
+func do(h http.Handler, method string, path string, ifMatch string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func decode(t *testing.T, res *httptest.ResponseRecorder, v interface{}) {
+	if err := json.Unmarshal(res.Body.Bytes(), v); err != nil {
+		t.Fatalf("cannot decode %s: %v", res.Body.String(), err)
+	}
+}
@@ -1,6 +1,7 @@
 package sql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"reflect"
@@ -13,7 +14,7 @@ import (
 )
 
 //New creates a new SQL database with the specified connection configuration
-func New(c jsql.Connection) (items.IDb, error) {
+func New(c jsql.Connection, options ...items.Option) (items.IDb, error) {
 	if err := c.Validate(); err != nil {
 		return nil, errors.Wrapf(err, "invalid sql config")
 	}
@@ -24,7 +25,7 @@ func New(c jsql.Connection) (items.IDb, error) {
 
 	log.Debugf("Connected to %+v", c)
 	return &sqlDatabase{
-		IDb:  items.New(c.Database),
+		IDb:  items.New(c.Database, options...),
 		conn: sqlConn,
 	}, nil
 }
@@ -36,11 +37,15 @@ type sqlDatabase struct {
 }
 
 func (db *sqlDatabase) Table(name string, tmplStruct items.IData) (items.ITable, error) {
+	return db.TableContext(context.Background(), name, tmplStruct)
+}
+
+func (db *sqlDatabase) TableContext(ctx context.Context, name string, tmplStruct items.IData) (items.ITable, error) {
 	//we get here to add the table to SQL before it is accepted into the items.IDb that we embed
 	log.Debugf("sqlDatabase.AddTable(conn=%v)", db.conn)
 
 	//see if can add to the db, but delete if not able to add to SQL
-	t, err := db.IDb.Table(name, tmplStruct)
+	t, err := db.IDb.TableContext(ctx, name, tmplStruct)
 	if err != nil {
 		return nil, errors.Wrapf(err, "db(%s).table(%s) failed.", db.Name(), name)
 	}
@@ -53,6 +58,11 @@ func (db *sqlDatabase) Table(name string, tmplStruct items.IData) (items.ITable,
 
 	//create a new SQL table or validate the structure of an existing table
 	tableName := "tbl_" + name
+	curTableName := "cur_" + name
+	fieldDefs, err := structFieldDefs(t.Type())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to describe %s as SQL table fields", tableName)
+	}
 	existingTableFields, err := jsql.Describe(db.conn, tableName)
 	if err == nil {
 		log.Debugf("Table %s exists with %d fields:", tableName, len(existingTableFields))
@@ -61,31 +71,36 @@ func (db *sqlDatabase) Table(name string, tmplStruct items.IData) (items.ITable,
 		for i, tfd := range existingTableFields {
 			log.Errorf("   TODO compare existing SQL table field[%d]: %+v", i, tfd)
 		}
+		migrated, err := migrateTable(ctx, db.conn, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if migrated {
+			//the current table is rebuilt below with the new columns
+			if _, err := db.conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS `%s`", curTableName)); err != nil {
+				return nil, errors.Wrapf(err, "failed to drop table %s", curTableName)
+			}
+		}
 	} else {
 		//table does not exist, create
 		log.Debugf("Creating table %s ...:", tableName)
-		fieldDefs, err := structFieldDefs(t.Type())
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to describe %s as SQL table fields", tableName)
-		}
 
 		//if the table does not exist, it must be created, or
 		//the table must exist with the correct definition
 		sqlQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (", tableName)
 		//header fields
-		sqlQuery += " nid int AUTO_INCREMENT PRIMARY KEY"
-		sqlQuery += ",uid char(40) NOT NULL"
-		sqlQuery += ",revNr int NOT NULL"
-		sqlQuery += ",revTs char(18) NOT NULL" //ts format: "CCYYMMDDHHMMSS.000" in UTC always
+		sqlQuery += " rowId int AUTO_INCREMENT PRIMARY KEY"
+		sqlQuery += "," + headerFieldDefs()
 		//user data fields from reflectType of user data struct
 		sqlQuery += "," + fieldDefs
 		//indexes and keys
 		sqlQuery += ",INDEX `idx_%s_uid` (uid)"
+		sqlQuery += ",INDEX (nid)"
 		sqlQuery += ",UNIQUE KEY (uid,revNr)"
 		//end of table definition
 		sqlQuery += ") ENGINE=InnoDB DEFAULT CHARSET=utf8"
 
-		rows, err := db.conn.Query(sqlQuery)
+		rows, err := db.conn.QueryContext(ctx, sqlQuery)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to create table %s: %s", tableName, sqlQuery)
 		}
@@ -93,21 +108,129 @@ func (db *sqlDatabase) Table(name string, tmplStruct items.IData) (items.ITable,
 			cols, _ := rows.Columns()
 			log.Debugf("Got a row: %+v", cols)
 		}
+		rows.Close()
+	}
+
+	//the current table has the latest revision of each item that is not deleted
+	//with the same columns as the history table, except rowId
+	var curTables int
+	queryStr := fmt.Sprintf("SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=\"%s\"", curTableName)
+	if err := db.conn.QueryRowContext(ctx, queryStr).Scan(&curTables); err != nil {
+		return nil, errors.Wrapf(err, "failed to check table %s", curTableName)
+	}
+	if curTables == 0 {
+		log.Debugf("Creating table %s ...:", curTableName)
+		sqlQuery := fmt.Sprintf("CREATE TABLE `%s` (", curTableName)
+		sqlQuery += headerFieldDefs()
+		sqlQuery += "," + fieldDefs
+		sqlQuery += ",PRIMARY KEY (uid)"
+		sqlQuery += ",INDEX (nid)"
+		sqlQuery += ") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+		if _, err := db.conn.ExecContext(ctx, sqlQuery); err != nil {
+			return nil, errors.Wrapf(err, "failed to create table %s: %s", curTableName, sqlQuery)
+		}
+
+		//fill it from the history of an existing table
+		columns := revFields + "," + items.StructFields(t.Type())
+		sqlQuery = fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s` AS r WHERE revNr=(SELECT MAX(revNr) FROM `%s` WHERE uid=r.uid) AND revDeleted=0",
+			curTableName, columns, columns, tableName, tableName)
+		if _, err := db.conn.ExecContext(ctx, sqlQuery); err != nil {
+			return nil, errors.Wrapf(err, "failed to fill table %s: %s", curTableName, sqlQuery)
+		}
+	}
+
+	//the purge table records the items that were purged, without their data
+	purgeTableName := "prg_" + name
+	sqlQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (", purgeTableName)
+	sqlQuery += " id int AUTO_INCREMENT PRIMARY KEY"
+	sqlQuery += ",uid char(40) NOT NULL"
+	sqlQuery += ",nid int NOT NULL"
+	sqlQuery += ",revisions int NOT NULL"
+	sqlQuery += ",ts DATETIME(6) NOT NULL"
+	sqlQuery += ",actor varchar(255) NOT NULL DEFAULT ''"
+	sqlQuery += ",reason varchar(255) NOT NULL DEFAULT ''"
+	sqlQuery += ") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+	if _, err := db.conn.ExecContext(ctx, sqlQuery); err != nil {
+		return nil, errors.Wrapf(err, "failed to create table %s: %s", purgeTableName, sqlQuery)
+	}
+
+	//the schedule table has the scheduled revisions with their data
+	schedTableName := "sch_" + name
+	sqlQuery = fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (", schedTableName)
+	sqlQuery += " id int AUTO_INCREMENT PRIMARY KEY"
+	sqlQuery += ",nid int NOT NULL"
+	sqlQuery += ",uid char(40) NOT NULL"
+	sqlQuery += ",revNr int NOT NULL"
+	sqlQuery += ",dueTs DATETIME(6) NOT NULL"
+	sqlQuery += ",revActor varchar(255) NOT NULL DEFAULT ''"
+	sqlQuery += ",revReason varchar(255) NOT NULL DEFAULT ''"
+	sqlQuery += ",revAnnotations TEXT"
+	sqlQuery += ",validFrom DATETIME(6) NULL"
+	sqlQuery += ",validTo DATETIME(6) NULL"
+	sqlQuery += ",revExpires DATETIME(6) NULL"
+	sqlQuery += ",failure varchar(255) NOT NULL DEFAULT ''"
+	sqlQuery += "," + fieldDefs
+	sqlQuery += ",INDEX (dueTs)"
+	sqlQuery += ") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+	if _, err := db.conn.ExecContext(ctx, sqlQuery); err != nil {
+		return nil, errors.Wrapf(err, "failed to create table %s: %s", schedTableName, sqlQuery)
+	}
+
+	//the draft table has the proposed revisions with their data until they are approved or rejected
+	draftTableName := "drf_" + name
+	sqlQuery = fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (", draftTableName)
+	sqlQuery += " id int AUTO_INCREMENT PRIMARY KEY"
+	sqlQuery += ",nid int NOT NULL"
+	sqlQuery += ",uid char(40) NOT NULL"
+	sqlQuery += ",revNr int NOT NULL"
+	sqlQuery += ",ts DATETIME(6) NOT NULL"
+	sqlQuery += ",revActor varchar(255) NOT NULL DEFAULT ''"
+	sqlQuery += ",revReason varchar(255) NOT NULL DEFAULT ''"
+	sqlQuery += ",revAnnotations TEXT"
+	sqlQuery += ",validFrom DATETIME(6) NULL"
+	sqlQuery += ",validTo DATETIME(6) NULL"
+	sqlQuery += ",revExpires DATETIME(6) NULL"
+	sqlQuery += "," + fieldDefs
+	sqlQuery += ",INDEX (uid)"
+	sqlQuery += ") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+	if _, err := db.conn.ExecContext(ctx, sqlQuery); err != nil {
+		return nil, errors.Wrapf(err, "failed to create table %s: %s", draftTableName, sqlQuery)
 	}
 
 	//SQL happy, call the embedded method to make it part of the database
 	//and wrap the table in an sqlTable so we will be called for all table operations
 	log.Debugf("SQL Table ok. Adding to db...")
 	st := &sqlTable{
-		ITable:        t,
-		conn:          db.conn,
-		tableName:     tableName,
-		csvFieldNames: items.StructFields(t.Type()),
+		ITable:         t,
+		conn:           db.conn,
+		tableName:      tableName,
+		curTableName:   curTableName,
+		purgeTableName: purgeTableName,
+		schedTableName: schedTableName,
+		draftTableName: draftTableName,
+		csvFieldNames:  items.StructFields(t.Type()),
+		index:          make(map[string]items.IIndex),
+	}
+	if err := st.loadNextDue(ctx); err != nil {
+		return nil, err
 	}
+	db.SetTable(st)
 	t = nil
 	return st, nil
 }
 
+//headerFieldDefs are the definitions of the revision columns before the item fields, see revFields
+func headerFieldDefs() string {
+	defs := "nid int NOT NULL DEFAULT 0" //rowId of rev 1, kept in all revisions
+	defs += ",uid char(40) NOT NULL"
+	defs += ",revNr int NOT NULL"
+	defs += ",revTs DATETIME(6) NOT NULL" //in UTC always
+	for _, col := range revColumns {
+		defs += "," + col.name + " " + col.def
+	}
+	return defs
+}
+
 func structFieldDefs(structType reflect.Type) (string, error) {
 	fieldDef := ""
 	for fieldIndex := 0; fieldIndex < structType.NumField(); fieldIndex++ {
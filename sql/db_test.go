@@ -2,6 +2,7 @@ package sql
 
 import (
 	"testing"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jansemmelink/items"
@@ -25,3 +26,68 @@ func TestDb(t *testing.T) {
 		t.Fatalf("db tests failed: %v", err)
 	}
 }
+
+func TestOptions(t *testing.T) {
+	if err := items.RunOptionTests(func(options ...items.Option) (items.IDb, error) {
+		return New(jsql.Connection{
+			User:     "store_api",
+			Pass:     "store",
+			Database: "store",
+		}, options...)
+	}); err != nil {
+		t.Fatalf("option tests failed: %v", err)
+	}
+}
+
+type migrated struct {
+	Name string
+}
+
+func (migrated) Validate() error {
+	return nil
+}
+
+func TestMigrate(t *testing.T) {
+	c := jsql.Connection{
+		User:     "store_api",
+		Pass:     "store",
+		Database: "store",
+	}
+	conn, err := c.Connect()
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	//a table in the old format, with millisecond timestamps in char(18) ending with ".DEL" in deleted revisions
+	for _, sqlQuery := range []string{
+		"DROP TABLE IF EXISTS `tbl_migrated`,`cur_migrated`,`prg_migrated`,`sch_migrated`,`drf_migrated`",
+		"CREATE TABLE `tbl_migrated` (nid int AUTO_INCREMENT PRIMARY KEY,uid char(40) NOT NULL,revNr int NOT NULL,revTs char(18) NOT NULL,Name varchar(255) NOT NULL,UNIQUE KEY (uid,revNr)) ENGINE=InnoDB DEFAULT CHARSET=utf8",
+		"INSERT INTO `tbl_migrated` (uid,revNr,revTs,Name) VALUES (\"a\",1,\"20200102030405.123\",\"jan\"),(\"a\",2,\"20200102030406.DEL\",\"jan\"),(\"b\",1,\"20200102030407.456\",\"piet\")",
+	} {
+		if _, err := conn.Exec(sqlQuery); err != nil {
+			t.Fatalf("Failed to create old table: %v", err)
+		}
+	}
+
+	db, err := New(c)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	table, err := db.Table("migrated", migrated{})
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	revs, err := table.History("a")
+	if err != nil || len(revs) != 2 || !revs[1].Rev().Deleted() {
+		t.Fatalf("history of deleted item: %v,%v", revs, err)
+	}
+	if ts := revs[0].Rev().Timestamp(); !ts.Equal(time.Date(2020, 1, 2, 3, 4, 5, 123000000, time.UTC)) {
+		t.Fatalf("live revision ts=%v", ts)
+	}
+	if ts := revs[1].Rev().Timestamp(); !ts.Equal(time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)) {
+		t.Fatalf("deleted revision ts=%v", ts)
+	}
+	item, err := table.GetItem("b")
+	if err != nil || !item.Rev().Timestamp().Equal(time.Date(2020, 1, 2, 3, 4, 7, 456000000, time.UTC)) || item.Data().(migrated).Name != "piet" {
+		t.Fatalf("current item: %v,%v", item, err)
+	}
+}
@@ -0,0 +1,140 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/jansemmelink/items"
+	"github.com/pkg/errors"
+)
+
+//draftFields are the columns of each draft before the item fields
+const draftFields = "id,nid,uid,revNr,ts,revActor,revReason,revAnnotations,validFrom,validTo,revExpires"
+
+func (t *sqlTable) Propose(upd items.IItem) (items.Draft, error) {
+	return t.ProposeContext(context.Background(), upd)
+}
+
+func (t *sqlTable) ProposeContext(ctx context.Context, upd items.IItem) (items.Draft, error) {
+	if t == nil {
+		return items.Draft{}, fmt.Errorf("nil.Propose()")
+	}
+	d, err := items.NewDraft(ctx, t, upd)
+	if err != nil {
+		return items.Draft{}, err
+	}
+	values, err := itemValueList(d.Data)
+	if err != nil {
+		return items.Draft{}, errors.Wrapf(err, "failed to define %s values for SQL", t.Name())
+	}
+	revAnnotations, err := encodeAnnotations(d.Info.Annotations)
+	if err != nil {
+		return items.Draft{}, errors.Wrapf(err, "failed to encode %s revAnnotations", t.Name())
+	}
+	queryStr := fmt.Sprintf("INSERT INTO `%s` (%s,%s) VALUES (NULL,%d,\"%s\",%d,\"%s\",\"%s\",\"%s\",\"%s\",%s,%s,%s,%s)",
+		t.draftTableName, draftFields, t.csvFieldNames,
+		d.NID, escape(d.UID), d.RevNr, d.Timestamp.UTC().Format(revTsFormat),
+		escape(d.Info.Actor), escape(d.Info.Reason), escape(revAnnotations),
+		validTs(d.Info.ValidFrom), validTs(d.Info.ValidTo), validTs(d.Info.Expires), values)
+	result, err := t.conn.ExecContext(ctx, queryStr)
+	if err != nil {
+		return items.Draft{}, errors.Wrapf(err, "failed to propose %s.uid=%s with: %s", t.Name(), d.UID, queryStr)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return items.Draft{}, errors.Wrapf(err, "failed to get %s draft id", t.Name())
+	}
+	d.ID = int(id)
+	return d, nil
+} //sqlTable.ProposeContext()
+
+func (t *sqlTable) Drafts() ([]items.Draft, error) {
+	return t.DraftsContext(context.Background())
+}
+
+func (t *sqlTable) DraftsContext(ctx context.Context) ([]items.Draft, error) {
+	return t.getDrafts(ctx, "1")
+}
+
+func (t *sqlTable) Approve(id int) (items.IItem, error) {
+	return t.ApproveContext(context.Background(), id)
+}
+
+func (t *sqlTable) ApproveContext(ctx context.Context, id int) (items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.Approve()")
+	}
+	list, err := t.getDrafts(ctx, fmt.Sprintf("id=%d", id))
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.draft=%d", t.Name(), id)
+	}
+	ctx, upd, err := list[0].Next(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	//the draft is removed in the transaction that writes it, so it is approved only once
+	written, err := t.takeAndUpd(ctx, upd, fmt.Sprintf("DELETE FROM `%s` WHERE id=%d", t.draftTableName, id))
+	if err != nil {
+		return nil, err
+	}
+	if written == nil {
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.draft=%d", t.Name(), id)
+	}
+	return written, nil
+} //sqlTable.ApproveContext()
+
+func (t *sqlTable) Reject(id int) error {
+	return t.RejectContext(context.Background(), id)
+}
+
+func (t *sqlTable) RejectContext(ctx context.Context, id int) error {
+	queryStr := fmt.Sprintf("DELETE FROM `%s` WHERE id=%d", t.draftTableName, id)
+	result, err := t.conn.ExecContext(ctx, queryStr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to reject %s draft with: %s", t.Name(), queryStr)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.Wrapf(items.ErrNotFound, "%s.draft=%d", t.Name(), id)
+	}
+	return nil
+}
+
+//getDrafts returns the drafts that match the condition, oldest first
+func (t *sqlTable) getDrafts(ctx context.Context, where string) ([]items.Draft, error) {
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE %s ORDER BY id", draftFields, t.csvFieldNames, t.draftTableName, where)
+	rows, err := t.conn.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s drafts with: %s", t.Name(), queryStr)
+	}
+	defer rows.Close()
+
+	list := make([]items.Draft, 0)
+	for rows.Next() {
+		itemDataPtrValue := reflect.New(t.Type())
+		var d items.Draft
+		var revAnnotations sql.NullString
+		var validFrom, validTo, revExpires sql.NullTime
+		values := append([]interface{}{&d.ID, &d.NID, &d.UID, &d.RevNr, &d.Timestamp, &d.Info.Actor, &d.Info.Reason, &revAnnotations, &validFrom, &validTo, &revExpires},
+			itemValues(itemDataPtrValue.Interface().(items.IData))...)
+		if err := rows.Scan(values...); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse SQL row into %s draft", t.Name())
+		}
+		if revAnnotations.String != "" {
+			if err := json.Unmarshal([]byte(revAnnotations.String), &d.Info.Annotations); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s.uid=%s revAnnotations", t.Name(), d.UID)
+			}
+		}
+		d.Info.ValidFrom = validFrom.Time
+		d.Info.ValidTo = validTo.Time
+		d.Info.Expires = revExpires.Time
+		d.Data = itemDataPtrValue.Elem().Interface().(items.IData)
+		list = append(list, d)
+	}
+	return list, nil
+} //sqlTable.getDrafts()
@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jansemmelink/log"
+	"github.com/pkg/errors"
+)
+
+//revColumns are the revision header columns added after the original nid,uid,revNr,revTs
+//with their definitions, in the order they are added
+var revColumns = []struct {
+	name string
+	def  string
+}{
+	{name: "revDeleted", def: "tinyint(1) NOT NULL DEFAULT 0"},
+	{name: "revActor", def: "varchar(255) NOT NULL DEFAULT ''"},
+	{name: "revReason", def: "varchar(255) NOT NULL DEFAULT ''"},
+	{name: "revAnnotations", def: "TEXT"},
+	{name: "createdTs", def: "DATETIME(6) NULL"},
+	{name: "createdBy", def: "varchar(255) NOT NULL DEFAULT ''"},
+	{name: "validFrom", def: "DATETIME(6) NULL"},
+	{name: "validTo", def: "DATETIME(6) NULL"},
+	{name: "revExpires", def: "DATETIME(6) NULL"},
+}
+
+//migrateTable adds revision header columns that are missing in an existing table,
+//which was created by an older version of this package, sets stable nids
+//and converts the old char(18) timestamps, of which deleted revisions ended with ".DEL"
+//the history is kept, but the milliseconds of old deleted revisions are lost
+//it returns true if the table was changed
+func migrateTable(ctx context.Context, conn *sql.DB, tableName string) (bool, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT COLUMN_NAME,DATA_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=\"%s\"", escape(tableName)))
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get columns of table %s", tableName)
+	}
+	migrated := false
+	existing := make(map[string]string) //data type by column name
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			rows.Close()
+			return false, errors.Wrapf(err, "failed to parse column of table %s", tableName)
+		}
+		existing[name] = dataType
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, errors.Wrapf(err, "failed to get columns of table %s", tableName)
+	}
+
+	//nid was the AUTO_INCREMENT row id, which changed with each revision
+	//keep it as rowId and set nid in all revisions to the rowId of the first revision
+	//each step is checked on its own, to complete a migration that failed halfway
+	if _, ok := existing["rowId"]; !ok {
+		sqlQuery := fmt.Sprintf("ALTER TABLE `%s` CHANGE COLUMN nid rowId int AUTO_INCREMENT", tableName)
+		if _, err := conn.ExecContext(ctx, sqlQuery); err != nil {
+			return false, errors.Wrapf(err, "failed to migrate nid of table %s: %s", tableName, sqlQuery)
+		}
+		existing["rowId"] = existing["nid"]
+		delete(existing, "nid")
+		migrated = true
+	}
+	if _, ok := existing["nid"]; !ok {
+		sqlQuery := fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN nid int NOT NULL DEFAULT 0 AFTER rowId, ADD INDEX (nid)", tableName)
+		if _, err := conn.ExecContext(ctx, sqlQuery); err != nil {
+			return false, errors.Wrapf(err, "failed to migrate nid of table %s: %s", tableName, sqlQuery)
+		}
+		migrated = true
+	}
+	//revisions are written with their nid in one transaction, so only migrated revisions have nid 0
+	var unset int
+	sqlQuery := fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE nid=0", tableName)
+	if err := conn.QueryRowContext(ctx, sqlQuery).Scan(&unset); err != nil {
+		return false, errors.Wrapf(err, "failed to check nid of table %s: %s", tableName, sqlQuery)
+	}
+	if unset > 0 {
+		sqlQuery := fmt.Sprintf("UPDATE `%s` AS r JOIN (SELECT uid,MIN(rowId) AS nid FROM `%s` GROUP BY uid) AS f ON f.uid=r.uid SET r.nid=f.nid WHERE r.nid=0", tableName, tableName)
+		if _, err := conn.ExecContext(ctx, sqlQuery); err != nil {
+			return false, errors.Wrapf(err, "failed to migrate nid of table %s: %s", tableName, sqlQuery)
+		}
+		log.Debugf("Migrated nid of table %s", tableName)
+		migrated = true
+	}
+
+	after := "revTs"
+	for _, col := range revColumns {
+		if _, ok := existing[col.name]; !ok {
+			sqlQuery := fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN %s %s AFTER %s", tableName, col.name, col.def, after)
+			if _, err := conn.ExecContext(ctx, sqlQuery); err != nil {
+				return false, errors.Wrapf(err, "failed to add column %s to table %s: %s", col.name, tableName, sqlQuery)
+			}
+			log.Debugf("Added column %s to table %s", col.name, tableName)
+			migrated = true
+		}
+		after = col.name
+	}
+
+	//old char(18) timestamps "CCYYMMDDHHMMSS.000" or "CCYYMMDDHHMMSS.DEL" in UTC
+	if existing["revTs"] == "char" {
+		sqlQuery := fmt.Sprintf("UPDATE `%s` SET revDeleted=1 WHERE revTs LIKE \"%%.DEL\"", tableName)
+		if _, err := conn.ExecContext(ctx, sqlQuery); err != nil {
+			return false, errors.Wrapf(err, "failed to set deleted revisions in table %s: %s", tableName, sqlQuery)
+		}
+		if err := migrateTs(ctx, conn, tableName, "revTs", "DATETIME(6) NOT NULL"); err != nil {
+			return false, err
+		}
+		migrated = true
+	}
+	if existing["createdTs"] == "char" {
+		if err := migrateTs(ctx, conn, tableName, "createdTs", "DATETIME(6) NULL"); err != nil {
+			return false, err
+		}
+		migrated = true
+	}
+
+	//copy the creation details from rev 1 into all revisions, if not pruned yet
+	if _, ok := existing["createdTs"]; !ok {
+		sqlQuery := fmt.Sprintf("UPDATE `%s` AS r JOIN `%s` AS c ON c.uid=r.uid AND c.revNr=1 SET r.createdTs=c.revTs,r.createdBy=c.revActor", tableName, tableName)
+		if _, err := conn.ExecContext(ctx, sqlQuery); err != nil {
+			return false, errors.Wrapf(err, "failed to set created details in table %s: %s", tableName, sqlQuery)
+		}
+	}
+	return migrated, nil
+} //migrateTable()
+
+//migrateTs converts an old char(18) timestamp column to a DATETIME(6) column with the definition
+func migrateTs(ctx context.Context, conn *sql.DB, tableName string, name string, def string) error {
+	for _, sqlQuery := range []string{
+		fmt.Sprintf("ALTER TABLE `%s` CHANGE COLUMN %s %sOld char(18)", tableName, name, name),
+		fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN %s DATETIME(6) NULL AFTER %sOld", tableName, name, name),
+		//only deleted revisions lose their milliseconds, which were replaced by "DEL"
+		fmt.Sprintf("UPDATE `%s` SET %s=STR_TO_DATE(CASE WHEN %sOld LIKE \"%%.DEL\" THEN CONCAT(SUBSTRING(%sOld,1,14),\".000\") ELSE %sOld END,\"%%Y%%m%%d%%H%%i%%s.%%f\") WHERE %sOld<>\"\"",
+			tableName, name, name, name, name, name),
+		fmt.Sprintf("ALTER TABLE `%s` MODIFY COLUMN %s %s", tableName, name, def),
+		fmt.Sprintf("ALTER TABLE `%s` DROP COLUMN %sOld", tableName, name),
+	} {
+		if _, err := conn.ExecContext(ctx, sqlQuery); err != nil {
+			return errors.Wrapf(err, "failed to convert %s of table %s: %s", name, tableName, sqlQuery)
+		}
+	}
+	log.Debugf("Converted %s of table %s to DATETIME(6)", name, tableName)
+	return nil
+}
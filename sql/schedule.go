@@ -0,0 +1,215 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jansemmelink/items"
+	"github.com/jansemmelink/log"
+	"github.com/pkg/errors"
+)
+
+//schedFields are the columns of each scheduled revision before the item fields
+const schedFields = "id,nid,uid,revNr,dueTs,revActor,revReason,revAnnotations,validFrom,validTo,revExpires,failure"
+
+func (t *sqlTable) Schedule(upd items.IItem, at time.Time) (items.ScheduledRev, error) {
+	return t.ScheduleContext(context.Background(), upd, at)
+}
+
+func (t *sqlTable) ScheduleContext(ctx context.Context, upd items.IItem, at time.Time) (items.ScheduledRev, error) {
+	if t == nil {
+		return items.ScheduledRev{}, fmt.Errorf("nil.Schedule()")
+	}
+	s, err := items.NewScheduledRev(ctx, t, upd, at)
+	if err != nil {
+		return items.ScheduledRev{}, err
+	}
+	if s.ID, err = t.insertScheduled(ctx, s); err != nil {
+		return items.ScheduledRev{}, err
+	}
+	t.mutex.Lock()
+	if t.nextDue.IsZero() || s.At.Before(t.nextDue) {
+		t.nextDue = s.At
+	}
+	t.mutex.Unlock()
+	return s, nil
+}
+
+func (t *sqlTable) Scheduled() ([]items.ScheduledRev, error) {
+	return t.ScheduledContext(context.Background())
+}
+
+func (t *sqlTable) ScheduledContext(ctx context.Context) ([]items.ScheduledRev, error) {
+	t.applyDue(ctx)
+	return t.getScheduled(ctx, "1")
+}
+
+func (t *sqlTable) CancelScheduled(id int) error {
+	return t.CancelScheduledContext(context.Background(), id)
+}
+
+func (t *sqlTable) CancelScheduledContext(ctx context.Context, id int) error {
+	queryStr := fmt.Sprintf("DELETE FROM `%s` WHERE id=%d", t.schedTableName, id)
+	result, err := t.conn.ExecContext(ctx, queryStr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to cancel %s scheduled revision with: %s", t.Name(), queryStr)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.Wrapf(items.ErrNotFound, "%s.scheduled=%d", t.Name(), id)
+	}
+	return t.loadNextDue(ctx)
+}
+
+//nextDueRefresh is how often applyDue() reads the time of the first pending scheduled revision again,
+//to see the revisions scheduled by other processes that use the table
+const nextDueRefresh = time.Second
+
+//applyingKey is in the context of the reads and writes of applyDue()
+type applyingKey struct{}
+
+//applyDue writes the scheduled revisions that are due, before the table is read
+//each revision is removed from the schedule table in the transaction in which it is written,
+//so it is written only once, also when other processes use the table,
+//and revisions that cannot be written are kept with their failure
+func (t *sqlTable) applyDue(ctx context.Context) {
+	if ctx.Value(applyingKey{}) != nil {
+		//writing a scheduled revision reads the table
+		return
+	}
+	t.mutex.Lock()
+	refresh := time.Since(t.nextDueLoaded) >= nextDueRefresh
+	t.mutex.Unlock()
+	if refresh {
+		if err := t.loadNextDue(ctx); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+	}
+	t.mutex.Lock()
+	nextDue := t.nextDue
+	t.mutex.Unlock()
+	if nextDue.IsZero() {
+		//do not look at the clock when there is nothing to do
+		return
+	}
+	now := t.Db().Now()
+	if now.Before(nextDue) {
+		return
+	}
+
+	//one at a time in this process, while other processes wait for the rows taken in the transactions
+	t.applyMutex.Lock()
+	defer t.applyMutex.Unlock()
+	ctx = context.WithValue(ctx, applyingKey{}, true)
+	due, err := t.getScheduled(ctx, fmt.Sprintf("failure=\"\" AND dueTs<=\"%s\"", now.UTC().Format(revTsFormat)))
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	for _, s := range due {
+		if err := t.writeScheduled(ctx, s); err != nil && ctx.Err() == nil {
+			failure := err.Error()
+			if len(failure) > 255 {
+				failure = failure[:255]
+			}
+			queryStr := fmt.Sprintf("UPDATE `%s` SET failure=\"%s\" WHERE id=%d", t.schedTableName, escape(failure), s.ID)
+			if _, err := t.conn.ExecContext(ctx, queryStr); err != nil {
+				log.Errorf("failed to keep %s scheduled revision failure with: %s: %v", t.Name(), queryStr, err)
+			}
+		}
+	}
+	if err := t.loadNextDue(ctx); err != nil {
+		log.Errorf("%v", err)
+	}
+} //sqlTable.applyDue()
+
+//writeScheduled takes the scheduled revision from the schedule table and writes it in one transaction
+//it does nothing when the revision was taken by another process
+func (t *sqlTable) writeScheduled(ctx context.Context, s items.ScheduledRev) error {
+	ctx, upd := s.Next(ctx, t)
+	_, err := t.takeAndUpd(ctx, upd, fmt.Sprintf("DELETE FROM `%s` WHERE id=%d AND failure=\"\"", t.schedTableName, s.ID))
+	return err
+} //sqlTable.writeScheduled()
+
+//loadNextDue gets the time of the first pending scheduled revision
+func (t *sqlTable) loadNextDue(ctx context.Context) error {
+	var nextDue sql.NullTime
+	queryStr := fmt.Sprintf("SELECT MIN(dueTs) FROM `%s` WHERE failure=\"\"", t.schedTableName)
+	if err := t.conn.QueryRowContext(ctx, queryStr).Scan(&nextDue); err != nil {
+		return errors.Wrapf(err, "failed to get %s scheduled revisions with: %s", t.Name(), queryStr)
+	}
+	t.mutex.Lock()
+	t.nextDue = nextDue.Time
+	t.nextDueLoaded = time.Now()
+	t.mutex.Unlock()
+	return nil
+}
+
+//insertScheduled writes a scheduled revision and returns its id
+//the id is assigned by SQL when s.ID is 0
+func (t *sqlTable) insertScheduled(ctx context.Context, s items.ScheduledRev) (int, error) {
+	values, err := itemValueList(s.Data)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to define %s values for SQL", t.Name())
+	}
+	revAnnotations, err := encodeAnnotations(s.Info.Annotations)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to encode %s revAnnotations", t.Name())
+	}
+	id := "NULL"
+	if s.ID > 0 {
+		id = fmt.Sprintf("%d", s.ID)
+	}
+	queryStr := fmt.Sprintf("INSERT INTO `%s` (%s,%s) VALUES (%s,%d,\"%s\",%d,\"%s\",\"%s\",\"%s\",\"%s\",%s,%s,%s,\"%s\",%s)",
+		t.schedTableName, schedFields, t.csvFieldNames,
+		id, s.NID, escape(s.UID), s.RevNr, s.At.UTC().Format(revTsFormat),
+		escape(s.Info.Actor), escape(s.Info.Reason), escape(revAnnotations),
+		validTs(s.Info.ValidFrom), validTs(s.Info.ValidTo), validTs(s.Info.Expires), escape(s.Failure), values)
+	result, err := t.conn.ExecContext(ctx, queryStr)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to schedule %s.uid=%s with: %s", t.Name(), s.UID, queryStr)
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get %s scheduled revision id", t.Name())
+	}
+	return int(lastID), nil
+} //sqlTable.insertScheduled()
+
+//getScheduled returns the scheduled revisions that match the condition, by time
+func (t *sqlTable) getScheduled(ctx context.Context, where string) ([]items.ScheduledRev, error) {
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE %s ORDER BY dueTs,id", schedFields, t.csvFieldNames, t.schedTableName, where)
+	rows, err := t.conn.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s scheduled revisions with: %s", t.Name(), queryStr)
+	}
+	defer rows.Close()
+
+	list := make([]items.ScheduledRev, 0)
+	for rows.Next() {
+		itemDataPtrValue := reflect.New(t.Type())
+		var s items.ScheduledRev
+		var revAnnotations sql.NullString
+		var validFrom, validTo, revExpires sql.NullTime
+		values := append([]interface{}{&s.ID, &s.NID, &s.UID, &s.RevNr, &s.At, &s.Info.Actor, &s.Info.Reason, &revAnnotations, &validFrom, &validTo, &revExpires, &s.Failure},
+			itemValues(itemDataPtrValue.Interface().(items.IData))...)
+		if err := rows.Scan(values...); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse SQL row into %s scheduled revision", t.Name())
+		}
+		if revAnnotations.String != "" {
+			if err := json.Unmarshal([]byte(revAnnotations.String), &s.Info.Annotations); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s.uid=%s revAnnotations", t.Name(), s.UID)
+			}
+		}
+		s.Info.ValidFrom = validFrom.Time
+		s.Info.ValidTo = validTo.Time
+		s.Info.Expires = revExpires.Time
+		s.Data = itemDataPtrValue.Elem().Interface().(items.IData)
+		list = append(list, s)
+	}
+	return list, nil
+} //sqlTable.getScheduled()
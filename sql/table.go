@@ -1,216 +1,1377 @@
 package sql
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jansemmelink/items"
 	"github.com/jansemmelink/log"
 	"github.com/pkg/errors"
-	uuid "github.com/satori/go.uuid"
 )
 
 type sqlTable struct {
 	items.ITable
-	conn          *sql.DB
-	tableName     string
+	conn           *sql.DB
+	tableName      string
+	curTableName   string
+	purgeTableName string
+	schedTableName string
+	draftTableName string
+	//nextDue is the time of the first pending scheduled revision, zero if none, as loaded at nextDueLoaded
+	nextDue       time.Time
+	nextDueLoaded time.Time
+	//applyMutex is locked while applying scheduled revisions
+	applyMutex sync.Mutex
+	//publishMutex is locked while committing and publishing changes, see commit()
+	publishMutex  sync.Mutex
 	csvFieldNames string
+	mutex         sync.Mutex
+	index         map[string]items.IIndex
 }
 
-const revTsFormat = "20060102150405.000"
+//revTsFormat writes revision timestamps in UTC to DATETIME(6) columns
+//they are read as time.Time like item fields
+const revTsFormat = "2006-01-02 15:04:05.000000"
+
+//revFields are the columns of each revision before the item fields
+const revFields = "nid,uid,revNr,revTs,revDeleted,revActor,revReason,revAnnotations,createdTs,createdBy,validFrom,validTo,revExpires"
+
+//encodeAnnotations of a revision as JSON for the revAnnotations column, "" if none
+func encodeAnnotations(annotations map[string]string) (string, error) {
+	if len(annotations) == 0 {
+		return "", nil
+	}
+	jsonAnnotations, err := json.Marshal(annotations)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonAnnotations), nil
+}
+
+//validTs is the SQL value of a valid time or expiry time, NULL when it is not bounded
+func validTs(ts time.Time) string {
+	if ts.IsZero() {
+		return "NULL"
+	}
+	return "\"" + ts.UTC().Format(revTsFormat) + "\""
+}
 
 func (t *sqlTable) Count() int {
 	if t == nil {
 		return 0
 	}
-
-	queryStr := fmt.Sprintf("SELECT COUNT(*) FROM `%s` GROUP BY uid", t.tableName)
-	rows, err := t.conn.Query(queryStr)
+	count, err := t.CountContext(context.Background())
 	if err != nil {
-		log.Errorf("Failed to count %s with: %s", t.Name(), queryStr)
-		return 0
-	}
-	if !rows.Next() {
-		log.Errorf("No row from counting %s with: %s", t.Name(), queryStr)
+		log.Errorf("%v", err)
 		return 0
 	}
+	return count
+}
 
+func (t *sqlTable) CountContext(ctx context.Context) (int, error) {
+	t.applyDue(ctx)
+	//the current table has only the items that are not deleted
+	queryStr := fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE %s", t.curTableName, t.liveCondition())
 	var count int
-	if err = rows.Scan(&count); err != nil {
-		log.Errorf("Failed to parse count: %v", err)
-		return 0
+	if err := t.conn.QueryRowContext(ctx, queryStr).Scan(&count); err != nil {
+		return 0, errors.Wrapf(err, "Failed to count %s with: %s", t.Name(), queryStr)
 	}
-	return count
+	return count, nil
 }
 
 func (t *sqlTable) AddItem(itemData items.IData) (items.IItem, error) {
+	return t.AddItemContext(context.Background(), itemData)
+}
+
+func (t *sqlTable) AddItemContext(ctx context.Context, itemData items.IData) (items.IItem, error) {
 	if t == nil {
 		return nil, fmt.Errorf("nil.AddItem()")
 	}
-	if itemData == nil {
-		return nil, fmt.Errorf("%s.AddItem(nil)", t.Name())
+	newItem, err := t.prepareAdd(ctx, itemData)
+	if err != nil {
+		return nil, err
+	}
+	written, errs, err := t.insert(ctx, []items.IItem{newItem}, nil)
+	if err != nil {
+		return nil, err
 	}
-	if err := itemData.Validate(); err != nil {
-		return nil, errors.Wrapf(err, "invalid %v data", t.Type())
+	if len(errs) > 0 {
+		return nil, errs[0]
 	}
+	t.Hooks().After(items.Added, nil, written[0])
+	return written[0], nil
+} //sqlTable.AddItemContext()
 
-	//we try to insert the item into the SQL table
-	//and let SQL assign the incrementing ID, while we assign the uid here
-	uid := uuid.NewV1().String()
-	rev := items.Rev(1, time.Now())
-	values, err := itemValueDef(itemData)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to define %T values for SQL", itemData)
+func (t *sqlTable) AddItems(list []items.IData) ([]items.IItem, error) {
+	return t.AddItemsContext(context.Background(), list)
+}
+
+func (t *sqlTable) AddItemsContext(ctx context.Context, list []items.IData) ([]items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.AddItems()")
+	}
+	newItems := make([]items.IItem, len(list))
+	errs := make(map[int]error)
+	for n, itemData := range list {
+		newItem, err := t.prepareAdd(ctx, itemData)
+		if err != nil {
+			errs[n] = err
+			continue
+		}
+		newItems[n] = newItem
+	}
+	if len(errs) == 0 {
+		errs = t.checkBatch(newItems)
+	}
+	if len(errs) > 0 {
+		return nil, &items.BatchError{Table: t.Name(), Errs: errs}
 	}
 
-	queryStr := fmt.Sprintf("INSERT INTO `%s` SET uid=\"%s\",revNr=%d,revTs=\"%s\",%s", t.tableName, uid, rev.Nr(), rev.Timestamp().UTC().Format(revTsFormat), values)
-	result, err := t.conn.Exec(queryStr)
+	written, errs, err := t.insert(ctx, newItems, nil)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to insert %T with: %s", itemData, queryStr)
-		//todo: check duplicate keys... and other failures...
-		//e.g. mark user.name must be unique...
+		return nil, err
 	}
+	if len(errs) > 0 {
+		return nil, &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	for _, newItem := range written {
+		t.Hooks().After(items.Added, nil, newItem)
+	}
+	return written, nil
+} //sqlTable.AddItemsContext()
 
-	nid, err := result.LastInsertId()
-	newItem := items.NewItem(t, int(nid), uid, rev, itemData)
-
+//prepareAdd returns the new item to insert for itemData
+//SQL assigns the incrementing nid when it is inserted, while we assign the uid here
+func (t *sqlTable) prepareAdd(ctx context.Context, itemData items.IData) (items.IItem, error) {
+	if itemData == nil {
+		return nil, fmt.Errorf("%s.AddItem(nil)", t.Name())
+	}
+	uid := t.Db().NewUID()
+	rev := items.NewRev(1, t.Db().Now(), false, items.RevInfoFrom(ctx))
+	newItem, err := t.Hooks().Before(items.Added, nil, items.NewItem(t, 0, uid, rev, itemData))
+	if err != nil {
+		return nil, err
+	}
+	if err := newItem.Data().Validate(); err != nil {
+		return nil, &items.ErrInvalidData{Table: t.Name(), Err: err}
+	}
+	if err := items.CheckRefs(ctx, t, newItem.Data()); err != nil {
+		return nil, err
+	}
+	if err := t.checkIndexes(ctx, newItem); err != nil {
+		return nil, err
+	}
 	return newItem, nil
-	//return t.ITable.AddItem(data)
-} //sqlTable.AddItem()
+} //sqlTable.prepareAdd()
 
 func (t *sqlTable) UpdItem(upd items.IItem) (items.IItem, error) {
+	return t.UpdItemContext(context.Background(), upd)
+}
+
+func (t *sqlTable) UpdItemContext(ctx context.Context, upd items.IItem) (items.IItem, error) {
 	if t == nil {
 		return nil, fmt.Errorf("nil.UpdItem()")
 	}
+	cur, upd, err := t.prepareUpd(ctx, upd)
+	if err != nil {
+		return nil, err
+	}
+	referring, err := items.UpdRefs(ctx, t, []items.IItem{cur}, []items.IItem{upd})
+	if err != nil {
+		return nil, err
+	}
+	written, errs, err := t.insert(ctx, []items.IItem{upd}, []items.IItem{cur})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	t.Hooks().After(items.Updated, cur, written[0])
+	return written[0], items.ApplyRefs(ctx, referring)
+} //sqlTable.UpdItemContext()
 
+func (t *sqlTable) UpdItems(list []items.IItem) ([]items.IItem, error) {
+	return t.UpdItemsContext(context.Background(), list)
+}
+
+func (t *sqlTable) UpdItemsContext(ctx context.Context, list []items.IItem) ([]items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.UpdItems()")
+	}
+	curItems := make([]items.IItem, len(list))
+	updItems := make([]items.IItem, len(list))
+	errs := make(map[int]error)
+	for n, upd := range list {
+		cur, upd, err := t.prepareUpd(ctx, upd)
+		if err != nil {
+			errs[n] = err
+			continue
+		}
+		curItems[n] = cur
+		updItems[n] = upd
+	}
+	if len(errs) == 0 {
+		errs = t.checkBatch(updItems)
+	}
+	if len(errs) > 0 {
+		return nil, &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	referring, err := items.UpdRefs(ctx, t, curItems, updItems)
+	if err != nil {
+		return nil, err
+	}
+
+	written, errs, err := t.insert(ctx, updItems, curItems)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	for n, upd := range written {
+		t.Hooks().After(items.Updated, curItems[n], upd)
+	}
+	return written, items.ApplyRefs(ctx, referring)
+} //sqlTable.UpdItemsContext()
+
+//prepareUpd checks the next revision of an item
+//and returns the current revision and the next revision with the data to insert
+func (t *sqlTable) prepareUpd(ctx context.Context, upd items.IItem) (items.IItem, items.IItem, error) {
+	if upd == nil {
+		return nil, nil, fmt.Errorf("%s.UpdItem(nil)", t.Name())
+	}
 	//check table reference
 	if upd.Table() != t {
-		return nil, fmt.Errorf("%s.UpdItem(%d,%s) from other table(%s)", t.Name(), upd.NID(), upd.UID(), upd.Table().Name())
+		return nil, nil, fmt.Errorf("%s.UpdItem(%d,%s) from other table(%s)", t.Name(), upd.NID(), upd.UID(), upd.Table().Name())
 	}
 	//check valid rev nr
 	if upd.Rev().Nr() <= 1 {
-		return nil, fmt.Errorf("%s.UpdItem(%d,%s) with rev.nr=%d should be >1", t.Name(), upd.NID(), upd.UID(), upd.Rev().Nr())
+		return nil, nil, fmt.Errorf("%s.UpdItem(%d,%s) with rev.nr=%d should be >1", t.Name(), upd.NID(), upd.UID(), upd.Rev().Nr())
 	}
 
-	values, err := itemValueDef(upd.Data())
+	//make sure this will be the next rev
+	cur, err := t.GetItemContext(ctx, upd.UID())
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to define %T values for SQL", upd.Data())
+		return nil, nil, err
+	}
+	if upd.Rev().Nr() != cur.Rev().Nr()+1 {
+		return nil, nil, &items.ErrRevisionConflict{Table: t.Name(), UID: upd.UID(), Expected: upd.Rev().Nr() - 1, Actual: cur.Rev().Nr()}
+	}
+	if upd.NID() != cur.NID() {
+		return nil, nil, fmt.Errorf("%s.uid=%s nid=%d != %d", t.Name(), upd.UID(), upd.NID(), cur.NID())
 	}
 
-	//update is another insert with the next rev nr
-	//the rev nr is incremented by IITem before calling this
-	//and this insert will fail on duplicate key if the next rev nr is already used
-	//in that case, you need to get again to get the latest changes made by someone else, and then upd again
-	queryStr := fmt.Sprintf("INSERT INTO `%s` SET", t.tableName)
-	queryStr += fmt.Sprintf(" uid=\"%s\"", upd.UID())
-	queryStr += fmt.Sprintf(",revNr=%d,revTs=\"%s\"", upd.Rev().Nr(), upd.Rev().Timestamp().UTC().Format(revTsFormat))
-	queryStr += "," + values
+	upd, err = t.Hooks().Before(items.Updated, cur, items.WithCreated(items.ApplyRevInfo(ctx, upd), cur.Created()))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := upd.Data().Validate(); err != nil {
+		return nil, nil, &items.ErrInvalidData{Table: t.Name(), Err: err}
+	}
+	if err := items.CheckRefs(ctx, t, upd.Data()); err != nil {
+		return nil, nil, err
+	}
+	if err := t.checkIndexes(ctx, upd); err != nil {
+		return nil, nil, err
+	}
+	return cur, upd, nil
+} //sqlTable.prepareUpd()
 
-	result, err := t.conn.Exec(queryStr)
+func (t *sqlTable) GetItem(uid string) (items.IItem, error) {
+	if t == nil {
+		panic("nil.GetItem()")
+	}
+	return t.GetItemContext(context.Background(), uid)
+}
+
+func (t *sqlTable) GetItemContext(ctx context.Context, uid string) (items.IItem, error) {
+	return t.unexpired(t.current(ctx, uid))
+}
+
+//current gets the latest revision of an item, also when it expired
+func (t *sqlTable) current(ctx context.Context, uid string) (items.IItem, error) {
+	t.applyDue(ctx)
+	return t.getCurrent(ctx, fmt.Sprintf("uid=\"%s\"", escape(uid)))
+}
+
+//unexpired returns the item, or ErrDeleted if it expired, see items.TTL
+func (t *sqlTable) unexpired(item items.IItem, err error) (items.IItem, error) {
+	if err == nil && t.TTL().Expired(item, t.Db().Now) {
+		return nil, errors.Wrapf(items.ErrDeleted, "%s.uid=%s expired", t.Name(), item.UID())
+	}
+	return item, err
+}
+
+//liveCondition is the SQL condition for rows of the current table of items that did not expire, see items.TTL
+func (t *sqlTable) liveCondition() string {
+	ttl := t.TTL()
+	now := t.Db().Now()
+	nowStr := now.UTC().Format(revTsFormat)
+	if ttl.After > 0 {
+		return fmt.Sprintf("((revExpires IS NOT NULL AND revExpires>\"%s\") OR (revExpires IS NULL AND revTs>\"%s\"))",
+			nowStr, now.Add(-ttl.After).UTC().Format(revTsFormat))
+	}
+	return fmt.Sprintf("(revExpires IS NULL OR revExpires>\"%s\")", nowStr)
+}
+
+func (t *sqlTable) GetItemByNID(nid int) (items.IItem, error) {
+	return t.GetItemByNIDContext(context.Background(), nid)
+}
+
+func (t *sqlTable) GetItemByNIDContext(ctx context.Context, nid int) (items.IItem, error) {
+	if nid < 1 {
+		//rows of new items have nid 0 only until their insert is committed
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.nid=%d", t.Name(), nid)
+	}
+	t.applyDue(ctx)
+	return t.unexpired(t.getCurrent(ctx, fmt.Sprintf("nid=%d", nid)))
+}
+
+//getCurrent returns the item in the current table that matches the condition on uid or nid,
+//else it fails with ErrDeleted if the latest revision in the history is deleted, or ErrNotFound
+func (t *sqlTable) getCurrent(ctx context.Context, where string) (items.IItem, error) {
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE %s", revFields, t.csvFieldNames, t.curTableName, where)
+	rows, err := t.conn.QueryContext(ctx, queryStr)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to insert %s with: %s", t.Name(), queryStr)
+		return nil, errors.Wrapf(err, "failed to get %s.%s: sql=%s", t.Name(), where, queryStr)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return t.scanItem(rows)
 	}
 
-	nid, err := result.LastInsertId()
-	newItem := items.NewItem(t, int(nid), upd.UID(), upd.Rev(), upd.Data())
-	return newItem, nil
-} //sqlTable.UpdItem()
+	//not a current item: check if it was deleted
+	var deleted bool
+	queryStr = fmt.Sprintf("SELECT revDeleted FROM `%s` WHERE %s ORDER BY revNr DESC LIMIT 1", t.tableName, where)
+	err = t.conn.QueryRowContext(ctx, queryStr).Scan(&deleted)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.%s", t.Name(), where)
+	case err != nil:
+		return nil, errors.Wrapf(err, "failed to get %s.%s: sql=%s", t.Name(), where, queryStr)
+	case deleted:
+		return nil, errors.Wrapf(items.ErrDeleted, "%s.%s", t.Name(), where)
+	}
+	//latest revision not yet in the current table, i.e. written concurrently
+	return nil, errors.Wrapf(items.ErrNotFound, "%s.%s", t.Name(), where)
+} //sqlTable.getCurrent()
+
+func (t *sqlTable) Restore(uid string) (items.IItem, error) {
+	return t.RestoreContext(context.Background(), uid)
+}
 
-func (t *sqlTable) GetItem(uid string) items.IItem {
+func (t *sqlTable) RestoreContext(ctx context.Context, uid string) (items.IItem, error) {
 	if t == nil {
-		panic("nil.GetItem()")
+		return nil, fmt.Errorf("nil.Restore()")
+	}
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE uid=\"%s\" ORDER BY revNr DESC LIMIT 1", revFields, t.csvFieldNames, t.tableName, escape(uid))
+	rows, err := t.conn.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s.uid=%s: sql=%s", t.Name(), uid, queryStr)
+	}
+	if !rows.Next() {
+		rows.Close()
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.uid=%s", t.Name(), uid)
+	}
+	tombstone, err := t.scanItem(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !tombstone.Rev().Deleted() {
+		return nil, fmt.Errorf("%s.uid=%s is not deleted", t.Name(), uid)
+	}
+
+	//the deleted revision has the data of the item before it was deleted
+	restored := items.WithCreated(
+		items.NewItem(t, tombstone.NID(), uid, items.NewRev(tombstone.Rev().Nr()+1, t.Db().Now(), false, items.RevInfoFrom(ctx)), tombstone.Data()),
+		tombstone.Created())
+	restored, err = t.Hooks().Before(items.Added, nil, restored)
+	if err != nil {
+		return nil, err
+	}
+	if err := restored.Data().Validate(); err != nil {
+		return nil, &items.ErrInvalidData{Table: t.Name(), Err: err}
+	}
+	if err := items.CheckRefs(ctx, t, restored.Data()); err != nil {
+		return nil, err
+	}
+	if err := t.checkIndexes(ctx, restored); err != nil {
+		return nil, err
+	}
+
+	//the insert fails with a conflict if the item was written after we got the deleted revision
+	written, errs, err := t.insert(ctx, []items.IItem{restored}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	t.Hooks().After(items.Added, nil, written[0])
+	return written[0], nil
+} //sqlTable.RestoreContext()
+
+func (t *sqlTable) DelItem(old items.IItem) error {
+	return t.DelItemContext(context.Background(), old)
+}
+
+func (t *sqlTable) DelItemContext(ctx context.Context, old items.IItem) error {
+	if t == nil {
+		return fmt.Errorf("nil.DelItem()")
+	}
+	cur, old, err := t.prepareDel(ctx, old)
+	if err != nil {
+		return err
+	}
+	referring, err := items.DelRefs(ctx, t, cur)
+	if err != nil {
+		return err
+	}
+	written, errs, err := t.insert(ctx, []items.IItem{old}, []items.IItem{cur})
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	t.Hooks().After(items.Deleted, cur, written[0])
+	return items.ApplyRefs(ctx, referring)
+} //sqlTable.DelItemContext()
+
+func (t *sqlTable) DelItems(list []items.IItem) error {
+	return t.DelItemsContext(context.Background(), list)
+}
+
+func (t *sqlTable) DelItemsContext(ctx context.Context, list []items.IItem) error {
+	if t == nil {
+		return fmt.Errorf("nil.DelItems()")
+	}
+	curItems := make([]items.IItem, len(list))
+	delItems := make([]items.IItem, len(list))
+	errs := make(map[int]error)
+	for n, old := range list {
+		cur, old, err := t.prepareDel(ctx, old)
+		if err != nil {
+			errs[n] = err
+			continue
+		}
+		curItems[n] = cur
+		delItems[n] = old
+	}
+	if len(errs) == 0 {
+		errs = t.checkBatch(delItems)
+	}
+	if len(errs) > 0 {
+		return &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	referring, err := items.DelRefs(ctx, t, curItems...)
+	if err != nil {
+		return err
+	}
+
+	written, errs, err := t.insert(ctx, delItems, curItems)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &items.BatchError{Table: t.Name(), Errs: errs}
+	}
+	for n, tombstone := range written {
+		t.Hooks().After(items.Deleted, curItems[n], tombstone)
+	}
+	return items.ApplyRefs(ctx, referring)
+} //sqlTable.DelItemsContext()
+
+//prepareDel checks the deleted revision of an item
+//and returns the current revision and the deleted revision to insert
+func (t *sqlTable) prepareDel(ctx context.Context, old items.IItem) (items.IItem, items.IItem, error) {
+	if old == nil {
+		return nil, nil, fmt.Errorf("%s.DelItem(nil)", t.Name())
+	}
+	if old.Table() != t {
+		return nil, nil, fmt.Errorf("%s.DelItem(nid=%d,uid=%s) from other table=%s", t.Name(), old.NID(), old.UID(), old.Table().Name())
+	}
+
+	//make sure this will be the next rev, also deleting an item that expired
+	cur, err := t.current(ctx, old.UID())
+	if err != nil {
+		return nil, nil, err
+	}
+	if old.Rev().Nr() != cur.Rev().Nr()+1 {
+		return nil, nil, &items.ErrRevisionConflict{Table: t.Name(), UID: old.UID(), Expected: old.Rev().Nr() - 1, Actual: cur.Rev().Nr()}
+	}
+	if old.NID() != cur.NID() {
+		return nil, nil, fmt.Errorf("%s.uid=%s nid=%d != %d", t.Name(), old.UID(), old.NID(), cur.NID())
+	}
+
+	old, err = t.Hooks().Before(items.Deleted, cur, items.WithCreated(items.ApplyRevInfo(ctx, old), cur.Created()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return cur, items.WithCreated(items.NewItem(t, old.NID(), old.UID(), items.NewRev(old.Rev().Nr(), old.Rev().Timestamp(), true, items.RevInfoOf(old.Rev())), old.Data()), old.Created()), nil
+} //sqlTable.prepareDel()
+
+//insert writes revisions of items as one unit with a multi-row INSERT in a transaction:
+//new items (rev 1), next revisions of items or deleted revisions
+//and updates the current table in the same transaction
+//the insert fails if the uid already has a revision with the same nr, because of UNIQUE KEY (uid,revNr),
+//which means someone else wrote the item in the meantime, and it then returns the conflicts by position in the list
+//else it returns the written revisions with the nids of new items assigned from their SQL rowId,
+//after publishing them with the revisions they replaced (nil for new and restored items, or all when replaced is nil)
+func (t *sqlTable) insert(ctx context.Context, list []items.IItem, replaced []items.IItem) ([]items.IItem, map[int]error, error) {
+	tx, err := t.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to start %s transaction", t.Name())
+	}
+	defer tx.Rollback()
+	written, errs, err := t.insertTx(ctx, tx, list)
+	if err != nil || len(errs) > 0 {
+		return nil, errs, err
+	}
+	if err := t.commit(tx, replaced, written); err != nil {
+		return nil, nil, err
+	}
+	return written, nil, nil
+}
+
+//commit the transaction that wrote the revisions and publish them with the revisions they replaced,
+//while holding the publish lock, so that concurrent writers of the table publish in the order of their commits
+func (t *sqlTable) commit(tx *sql.Tx, replaced []items.IItem, written []items.IItem) error {
+	t.publishMutex.Lock()
+	defer t.publishMutex.Unlock()
+	if err := tx.Commit(); err != nil {
+		return errors.Wrapf(err, "failed to commit %s transaction", t.Name())
+	}
+	for n, item := range written {
+		var cur items.IItem
+		if replaced != nil {
+			cur = replaced[n]
+		}
+		switch {
+		case item.Rev().Deleted():
+			t.Feed().Publish(items.Deleted, cur, item)
+		case cur == nil:
+			t.Feed().Publish(items.Added, nil, item)
+		default:
+			t.Feed().Publish(items.Updated, cur, item)
+		}
+	}
+	return nil
+} //sqlTable.commit()
+
+//insertTx is insert() in a transaction that the caller commits
+func (t *sqlTable) insertTx(ctx context.Context, tx *sql.Tx, list []items.IItem) ([]items.IItem, map[int]error, error) {
+	queryStr := fmt.Sprintf("INSERT INTO `%s` (%s,%s) VALUES ", t.tableName, revFields, t.csvFieldNames)
+	keys := ""
+	newKeys := ""
+	liveKeys := ""
+	uids := ""
+	for n, item := range list {
+		values, err := itemValueList(item.Data())
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to define %s values for SQL", t.Name())
+		}
+		revAnnotations, err := encodeAnnotations(item.Rev().Annotations())
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to encode %s revAnnotations", t.Name())
+		}
+		if n > 0 {
+			queryStr += ","
+			keys += ","
+		}
+		created := item.Created()
+		if created == nil {
+			return nil, nil, fmt.Errorf("%s.uid=%s rev %d without created rev", t.Name(), item.UID(), item.Rev().Nr())
+		}
+		nid := item.NID()
+		if item.Rev().Nr() == 1 {
+			nid = 0 //set to the rowId after the insert
+			if newKeys != "" {
+				newKeys += ","
+			}
+			newKeys += fmt.Sprintf("(\"%s\",1)", escape(item.UID()))
+		}
+		queryStr += fmt.Sprintf("(%d,\"%s\",%d,\"%s\",%t,\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",%s,%s,%s,%s)", nid, escape(item.UID()), item.Rev().Nr(),
+			item.Rev().Timestamp().UTC().Format(revTsFormat), item.Rev().Deleted(),
+			escape(item.Rev().Actor()), escape(item.Rev().Reason()), escape(revAnnotations),
+			created.Timestamp().UTC().Format(revTsFormat), escape(created.Actor()),
+			validTs(item.Rev().ValidFrom()), validTs(item.Rev().ValidTo()), validTs(item.Rev().Expires()), values)
+		keys += fmt.Sprintf("(\"%s\",%d)", escape(item.UID()), item.Rev().Nr())
+		uids += fmt.Sprintf(",\"%s\"", escape(item.UID()))
+		if !item.Rev().Deleted() {
+			liveKeys += fmt.Sprintf(",(\"%s\",%d)", escape(item.UID()), item.Rev().Nr())
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, queryStr); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 { //ER_DUP_ENTRY
+			if errs := t.revConflicts(ctx, list); len(errs) > 0 {
+				return nil, errs, nil
+			}
+		}
+		return nil, nil, errors.Wrapf(err, "failed to insert %s with: %s", t.Name(), queryStr)
+	}
+
+	//new items keep the rowId of their first revision as nid
+	if newKeys != "" {
+		queryStr = fmt.Sprintf("UPDATE `%s` SET nid=rowId WHERE (uid,revNr) IN (%s)", t.tableName, newKeys)
+		if _, err := tx.ExecContext(ctx, queryStr); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to set %s nids with: %s", t.Name(), queryStr)
+		}
+	}
+
+	//replace the current revisions of the items, or remove deleted items
+	//not with REPLACE, which would remove other items with the same key in a unique index,
+	//while the insert fails when another item has the key, see IndexContext()
+	queryStr = fmt.Sprintf("DELETE FROM `%s` WHERE uid IN (%s)", t.curTableName, uids[1:])
+	if _, err := tx.ExecContext(ctx, queryStr); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to delete current %s with: %s", t.Name(), queryStr)
+	}
+	if liveKeys != "" {
+		columns := revFields + "," + t.csvFieldNames
+		queryStr = fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s` WHERE (uid,revNr) IN (%s)", t.curTableName, columns, columns, t.tableName, liveKeys[1:])
+		if _, err := tx.ExecContext(ctx, queryStr); err != nil {
+			var mysqlErr *mysql.MySQLError
+			if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 { //ER_DUP_ENTRY
+				if errs := t.duplicateKeys(ctx, tx, list); len(errs) > 0 {
+					return nil, errs, nil
+				}
+			}
+			return nil, nil, errors.Wrapf(err, "failed to update current %s with: %s", t.Name(), queryStr)
+		}
+	}
+
+	//get the nids of the inserted rows
+	nids := make(map[string]int)
+	queryStr = fmt.Sprintf("SELECT uid,nid FROM `%s` WHERE (uid,revNr) IN (%s)", t.tableName, keys)
+	rows, err := tx.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get %s nids with: %s", t.Name(), queryStr)
+	}
+	for rows.Next() {
+		var uid string
+		var nid int
+		if err := rows.Scan(&uid, &nid); err != nil {
+			rows.Close()
+			return nil, nil, errors.Wrapf(err, "failed to parse %s nid", t.Name())
+		}
+		nids[uid] = nid
+	}
+	rows.Close()
+
+	written := make([]items.IItem, len(list))
+	for n, item := range list {
+		written[n] = items.WithCreated(items.NewItem(t, nids[item.UID()], item.UID(), item.Rev(), item.Data()), item.Created())
+	}
+	return written, nil, nil
+} //sqlTable.insertTx()
+
+//takeAndUpd writes upd like UpdItem(), in one transaction with takeStr, which deletes the row
+//of the scheduled revision or draft that upd is written from, so that only one writer takes it
+//it returns nil without writing when the row was already taken
+func (t *sqlTable) takeAndUpd(ctx context.Context, upd items.IItem, takeStr string) (items.IItem, error) {
+	cur, upd, err := t.prepareUpd(ctx, upd)
+	if err != nil {
+		return nil, err
+	}
+	referring, err := items.UpdRefs(ctx, t, []items.IItem{cur}, []items.IItem{upd})
+	if err != nil {
+		return nil, err
+	}
+	tx, err := t.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start %s transaction", t.Name())
 	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, takeStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to take %s.uid=%s with: %s", t.Name(), upd.UID(), takeStr)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+	written, errs, err := t.insertTx(ctx, tx, []items.IItem{upd})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if err := t.commit(tx, []items.IItem{cur}, written); err != nil {
+		return nil, err
+	}
+	t.Hooks().After(items.Updated, cur, written[0])
+	return written[0], items.ApplyRefs(ctx, referring)
+} //sqlTable.takeAndUpd()
+
+func (t *sqlTable) Items() map[string]items.IItem {
+	if t == nil {
+		return make(map[string]items.IItem)
+	}
+	list, err := t.ItemsContext(context.Background())
+	if err != nil {
+		log.Errorf("%v", err)
+		return make(map[string]items.IItem)
+	}
+	return list
+}
+
+func (t *sqlTable) ItemsContext(ctx context.Context) (map[string]items.IItem, error) {
+	t.applyDue(ctx)
+	//the current table has only the latest revision of each item that is not deleted
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE %s", revFields, t.csvFieldNames, t.curTableName, t.liveCondition())
+	rows, err := t.conn.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get %s items with: %s", t.Name(), queryStr)
+	}
+	defer rows.Close()
+
+	list := make(map[string]items.IItem)
+	for rows.Next() {
+		item, err := t.scanItem(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get %s items", t.Name())
+		}
+		list[item.UID()] = item
+	}
+	return list, nil
+} //sqlTable.ItemsContext()
+
+func (t *sqlTable) GetItemAsOf(uid string, knownAt time.Time, validAt time.Time) (items.IItem, error) {
+	return t.GetItemAsOfContext(context.Background(), uid, knownAt, validAt)
+}
+
+func (t *sqlTable) GetItemAsOfContext(ctx context.Context, uid string, knownAt time.Time, validAt time.Time) (items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.GetItemAsOf()")
+	}
+	list, err := t.asOf(ctx, fmt.Sprintf("uid=\"%s\"", escape(uid)), knownAt, validAt)
+	if err != nil {
+		return nil, err
+	}
+	item, ok := list[uid]
+	if !ok {
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.uid=%s as of %v valid at %v", t.Name(), uid, knownAt, validAt)
+	}
+	if item.Rev().Deleted() {
+		return nil, errors.Wrapf(items.ErrDeleted, "%s.uid=%s as of %v valid at %v", t.Name(), uid, knownAt, validAt)
+	}
+	return item, nil
+}
+
+func (t *sqlTable) ItemsWith(field string, value interface{}) (map[string]items.IItem, error) {
+	return t.ItemsWithContext(context.Background(), field, value)
+}
+
+func (t *sqlTable) ItemsWithContext(ctx context.Context, field string, value interface{}) (map[string]items.IItem, error) {
+	t.applyDue(ctx)
+	structField, ok := t.Type().FieldByName(field)
+	if !ok {
+		return nil, fmt.Errorf("table %s does not have field %s", t.Name(), field)
+	}
+	fieldValue := reflect.ValueOf(value)
+	if !fieldValue.IsValid() || !fieldValue.Type().ConvertibleTo(structField.Type) {
+		return nil, fmt.Errorf("%s.%s is %v, not %T", t.Name(), field, structField.Type, value)
+	}
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE %s=%s AND %s", revFields, t.csvFieldNames, t.curTableName,
+		field, sqlValue(fieldValue.Convert(structField.Type)), t.liveCondition())
+	rows, err := t.conn.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s items with: %s", t.Name(), queryStr)
+	}
+	defer rows.Close()
+	list := make(map[string]items.IItem)
+	for rows.Next() {
+		item, err := t.scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		list[item.UID()] = item
+	}
+	return list, nil
+} //sqlTable.ItemsWithContext()
+
+//Ref also adds an index on the field to the current table, to find the referring items with ItemsWith()
+func (t *sqlTable) Ref(field string, target items.ITable, targetIndex string, onDel items.RefAction) error {
+	if err := t.ITable.Ref(field, target, targetIndex, onDel); err != nil {
+		return err
+	}
+	var n int
+	indexName := "ref_" + field
+	queryStr := fmt.Sprintf("SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=\"%s\" AND INDEX_NAME=\"%s\"",
+		escape(t.curTableName), escape(indexName))
+	if err := t.conn.QueryRow(queryStr).Scan(&n); err != nil {
+		return errors.Wrapf(err, "failed to get %s indexes with: %s", t.Name(), queryStr)
+	}
+	if n == 0 {
+		queryStr = fmt.Sprintf("ALTER TABLE `%s` ADD INDEX `%s` (%s)", t.curTableName, indexName, field)
+		if _, err := t.conn.Exec(queryStr); err != nil {
+			return errors.Wrapf(err, "failed to index %s.%s with: %s", t.Name(), field, queryStr)
+		}
+	}
+	return nil
+} //sqlTable.Ref()
+
+func (t *sqlTable) ItemsAsOf(knownAt time.Time, validAt time.Time) (map[string]items.IItem, error) {
+	return t.ItemsAsOfContext(context.Background(), knownAt, validAt)
+}
+
+func (t *sqlTable) ItemsAsOfContext(ctx context.Context, knownAt time.Time, validAt time.Time) (map[string]items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.ItemsAsOf()")
+	}
+	list, err := t.asOf(ctx, "1", knownAt, validAt)
+	if err != nil {
+		return nil, err
+	}
+	for uid, item := range list {
+		if item.Rev().Deleted() {
+			delete(list, uid)
+		}
+	}
+	return list, nil
+}
 
-	//get only the latest revNr:
-	queryStr := fmt.Sprintf("SELECT nid,revNr,revTs,%s FROM `%s` WHERE uid=\"%s\" ORDER BY revNr DESC LIMIT 1", t.csvFieldNames, t.tableName, uid)
-	rows, err := t.conn.Query(queryStr)
+//asOf returns the revisions as known at knownAt and effective at validAt of the items matching the condition,
+//including deleted revisions, with uid as map index, see items.RevisionAsOf()
+func (t *sqlTable) asOf(ctx context.Context, where string, knownAt time.Time, validAt time.Time) (map[string]items.IItem, error) {
+	t.applyDue(ctx)
+	validStr := validAt.UTC().Format(revTsFormat)
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE %s AND revTs<=\"%s\""+
+		" AND (validFrom IS NULL OR validFrom<=\"%s\") AND (validTo IS NULL OR validTo>\"%s\") ORDER BY uid,revNr",
+		revFields, t.csvFieldNames, t.tableName, where, knownAt.UTC().Format(revTsFormat), validStr, validStr)
+	rows, err := t.conn.QueryContext(ctx, queryStr)
 	if err != nil {
-		log.Debugf("ERROR: failed to get %s.uid=%s: sql=%s: %v", t.Name(), uid, queryStr, err)
-		return nil
+		return nil, errors.Wrapf(err, "failed to get %s items as of %v with: %s", t.Name(), knownAt, queryStr)
 	}
+	defer rows.Close()
 
+	//the last revision of each item is the latest one
+	list := make(map[string]items.IItem)
+	for rows.Next() {
+		item, err := t.scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		list[item.UID()] = item
+	}
+	return list, nil
+} //sqlTable.asOf()
+
+func (t *sqlTable) History(uid string) ([]items.IItem, error) {
+	return t.HistoryContext(context.Background(), uid)
+}
+
+func (t *sqlTable) HistoryContext(ctx context.Context, uid string) ([]items.IItem, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil.History()")
+	}
+	t.applyDue(ctx)
+
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE uid=\"%s\" ORDER BY revNr", revFields, t.csvFieldNames, t.tableName, escape(uid))
+	rows, err := t.conn.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s.uid=%s history: sql=%s", t.Name(), uid, queryStr)
+	}
+	defer rows.Close()
+
+	list := make([]items.IItem, 0)
+	for rows.Next() {
+		item, err := t.scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	if len(list) == 0 {
+		return nil, errors.Wrapf(items.ErrNotFound, "%s.History(%s)", t.Name(), uid)
+	}
+	return list, nil
+} //sqlTable.HistoryContext()
+
+//checkIndexes fails with ErrDuplicateKey if the item uses the key of another item in any index
+//before it is written, while the unique keys of the indexes in SQL fail the write when another item
+//takes the key in the meantime (see insert())
+//an item that expired but was not swept yet gets its deleted revision here, so that its key can be used
+func (t *sqlTable) checkIndexes(ctx context.Context, item items.IItem) error {
+	for _, index := range t.indexes() {
+		existing, err := t.keyItem(ctx, t.conn, index, item)
+		if err != nil {
+			return err
+		}
+		if existing == nil || existing.UID() == item.UID() {
+			continue
+		}
+		if !t.TTL().Expired(existing, t.Db().Now) {
+			return &items.ErrDuplicateKey{Table: t.Name(), Index: index.Name(), Key: index.ItemKey(item).String()}
+		}
+		sweepCtx := ctx
+		if items.RevInfoFrom(ctx).Reason == "" {
+			sweepCtx = items.WithReason(ctx, items.ExpiredReason)
+		}
+		if err := t.DelItemContext(sweepCtx, items.DeletedItem(existing)); err != nil {
+			var conflict *items.ErrRevisionConflict
+			if !errors.As(err, &conflict) && !errors.Is(err, items.ErrDeleted) {
+				return errors.Wrapf(err, "failed to sweep expired %s.uid=%s", t.Name(), existing.UID())
+			}
+		}
+	}
+	return nil
+} //sqlTable.checkIndexes()
+
+//indexes returns the indexes of the table
+func (t *sqlTable) indexes() []items.IIndex {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	list := make([]items.IIndex, 0, len(t.index))
+	for _, index := range t.index {
+		list = append(list, index)
+	}
+	return list
+}
+
+//querier is an SQL connection or transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+//keyItem returns the current item with the same key as item in the index, also when it expired, or nil
+func (t *sqlTable) keyItem(ctx context.Context, q querier, index items.IIndex, item items.IItem) (items.IItem, error) {
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE %s LIMIT 1", revFields, t.csvFieldNames, t.curTableName, keyCondition(index, item.Data()))
+	rows, err := q.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to check %s.%s with: %s", t.Name(), index.Name(), queryStr)
+	}
+	defer rows.Close()
 	if !rows.Next() {
-		log.Debugf("%s.uid=%s not found", t.Name(), uid)
-		return nil
+		return nil, nil
+	}
+	return t.scanItem(rows)
+}
+
+//keyCondition is the SQL condition for the key of data in the index
+func keyCondition(index items.IIndex, data items.IData) string {
+	dataValue := reflect.ValueOf(data)
+	where := ""
+	for _, fieldName := range index.Fields() {
+		where += fmt.Sprintf(" AND %s=%s", fieldName, sqlValue(dataValue.FieldByName(fieldName)))
+	}
+	return where[5:]
+}
+
+//duplicateKeys returns ErrDuplicateKey by position in the list for items that have the key of another item
+//in a unique index, after inserting them into the current table failed in the transaction
+func (t *sqlTable) duplicateKeys(ctx context.Context, tx *sql.Tx, list []items.IItem) map[int]error {
+	errs := make(map[int]error)
+	for n, item := range list {
+		if item.Rev().Deleted() {
+			continue
+		}
+		for _, index := range t.indexes() {
+			existing, err := t.keyItem(ctx, tx, index, item)
+			if err != nil {
+				log.Errorf("%v", err)
+				continue
+			}
+			if existing != nil && existing.UID() != item.UID() {
+				errs[n] = &items.ErrDuplicateKey{Table: t.Name(), Index: index.Name(), Key: index.ItemKey(item).String()}
+				break
+			}
+		}
+	}
+	return errs
+} //sqlTable.duplicateKeys()
+
+//checkBatch returns errors by position in the list for items that are written more than once
+//or that use the same key as another item in the list in any index
+func (t *sqlTable) checkBatch(list []items.IItem) map[int]error {
+	t.mutex.Lock()
+	indexes := make([]items.IIndex, 0, len(t.index))
+	for _, index := range t.index {
+		indexes = append(indexes, index)
+	}
+	t.mutex.Unlock()
+
+	errs := make(map[int]error)
+	uids := make(map[string]int)
+	keys := make(map[string]int)
+	for n, item := range list {
+		if other, ok := uids[item.UID()]; ok {
+			errs[n] = fmt.Errorf("%s.uid=%s also written by entry %d", t.Name(), item.UID(), other)
+			continue
+		}
+		uids[item.UID()] = n
+		if item.Rev().Deleted() {
+			continue
+		}
+		for _, index := range indexes {
+			keyString := index.ItemKey(item).String()
+			if _, ok := keys[index.Name()+":"+keyString]; ok {
+				errs[n] = &items.ErrDuplicateKey{Table: t.Name(), Index: index.Name(), Key: keyString}
+				break
+			}
+			keys[index.Name()+":"+keyString] = n
+		}
 	}
+	return errs
+} //sqlTable.checkBatch()
 
+//revConflicts returns ErrRevisionConflict by position in the list
+//for items that already have a revision at or after the revision in the list
+func (t *sqlTable) revConflicts(ctx context.Context, list []items.IItem) map[int]error {
+	errs := make(map[int]error)
+	for n, item := range list {
+		var actual int
+		queryStr := fmt.Sprintf("SELECT MAX(revNr) FROM `%s` WHERE uid=\"%s\"", t.tableName, escape(item.UID()))
+		if err := t.conn.QueryRowContext(ctx, queryStr).Scan(&actual); err != nil {
+			log.Errorf("failed to get latest %s.uid=%s revNr: %v", t.Name(), item.UID(), err)
+			continue
+		}
+		if actual >= item.Rev().Nr() {
+			errs[n] = &items.ErrRevisionConflict{Table: t.Name(), UID: item.UID(), Expected: item.Rev().Nr() - 1, Actual: actual}
+		}
+	}
+	return errs
+} //sqlTable.revConflicts()
+
+//scanItem parses the current row of a query that selected
+//"<revFields>,<csvFieldNames>" into an item
+func (t *sqlTable) scanItem(rows *sql.Rows) (items.IItem, error) {
 	itemDataPtrValue := reflect.New(t.Type())
 	itemData := itemDataPtrValue.Interface().(items.IData)
 	var nid int
+	var uid string
 	var revNr int
-	var revTsString string
-	values := append([]interface{}{&nid, &revNr, &revTsString}, itemValues(itemData)...)
-	if err = rows.Scan(values...); err != nil {
-		log.Debugf("ERROR: failed to parse SQL row into %v: %v", t.Type(), err)
-		return nil
+	var revTs time.Time
+	var revDeleted bool
+	var info items.RevInfo
+	var revAnnotations sql.NullString
+	var createdTs sql.NullTime
+	var createdBy string
+	var validFrom, validTo, revExpires sql.NullTime
+	values := append([]interface{}{&nid, &uid, &revNr, &revTs, &revDeleted, &info.Actor, &info.Reason, &revAnnotations, &createdTs, &createdBy, &validFrom, &validTo, &revExpires}, itemValues(itemData)...)
+	if err := rows.Scan(values...); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse SQL row into %v", t.Type())
+	}
+	if revAnnotations.String != "" {
+		if err := json.Unmarshal([]byte(revAnnotations.String), &info.Annotations); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s.uid=%s revAnnotations", t.Name(), uid)
+		}
 	}
 
-	//if revTsString ends with ".DEL", the item was deleted
-	if revTsString[14:] == ".DEL" {
-		return nil
+	info.ValidFrom = validFrom.Time
+	info.ValidTo = validTo.Time
+	info.Expires = revExpires.Time
+
+	//createdTs is NULL only in rows of a migrated table of which rev 1 was already pruned
+	rev := items.NewRev(revNr, revTs, revDeleted, info)
+	log.Debugf("Parsed %s.nid=%d,uid=%s: %+v", t.Name(), nid, uid, itemData)
+
+	//dereference the itemData to return the struct, not a pointer to the struct:
+	item := items.NewItem(t, nid, uid, rev, itemDataPtrValue.Elem().Interface().(items.IData))
+	return items.WithCreated(item, items.CreatedRev(createdTs.Time, createdBy)), nil
+} //sqlTable.scanItem()
+
+func (t *sqlTable) Prune() (int, error) {
+	return t.PruneContext(context.Background())
+}
+
+func (t *sqlTable) PruneContext(ctx context.Context) (int, error) {
+	if t == nil {
+		return 0, fmt.Errorf("nil.Prune()")
 	}
+	retention := t.Retention()
 
-	revTs, err := time.Parse(revTsFormat, revTsString)
+	//prune all items or none
+	tx, err := t.conn.BeginTx(ctx, nil)
 	if err != nil {
-		log.Errorf("ERROR: failed to parse revTs=%s into %v: %v", revTsString, revTsFormat, err)
-		return nil
+		return 0, errors.Wrapf(err, "failed to start %s transaction", t.Name())
 	}
-	log.Debugf("Parsed %s.nid=%d,uid=%s: %+v", t.Name(), nid, uid, itemData)
+	defer tx.Rollback()
 
-	//dereference the itemData to return the struct, not a pointer to the struct:
-	return items.NewItem(t, nid, uid, items.Rev(revNr, revTs), itemDataPtrValue.Elem().Interface().(items.IData))
-} //sqlTable.GetItem()
+	//get all revisions without their data
+	revs := make(map[string][]items.IRev)
+	queryStr := fmt.Sprintf("SELECT uid,revNr,revTs,revDeleted FROM `%s` ORDER BY uid,revNr", t.tableName)
+	rows, err := tx.QueryContext(ctx, queryStr)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get %s revisions: sql=%s", t.Name(), queryStr)
+	}
+	for rows.Next() {
+		var uid string
+		var revNr int
+		var revTs time.Time
+		var revDeleted bool
+		if err := rows.Scan(&uid, &revNr, &revTs, &revDeleted); err != nil {
+			rows.Close()
+			return 0, errors.Wrapf(err, "failed to parse %s revision", t.Name())
+		}
+		revs[uid] = append(revs[uid], items.NewRev(revNr, revTs, revDeleted, items.RevInfo{}))
+	}
+	rows.Close()
 
-func (t *sqlTable) DelItem(old items.IItem) error {
+	now := t.Db().Now()
+	count := 0
+	for uid, list := range revs {
+		drop := retention.Drop(list, now)
+		if len(drop) == 0 {
+			continue
+		}
+		revNrs := ""
+		for _, revNr := range drop {
+			revNrs += fmt.Sprintf(",%d", revNr)
+		}
+		queryStr := fmt.Sprintf("DELETE FROM `%s` WHERE uid=\"%s\" AND revNr IN (%s)", t.tableName, escape(uid), revNrs[1:])
+		result, err := tx.ExecContext(ctx, queryStr)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to prune %s.%s with: %s", t.Name(), uid, queryStr)
+		}
+		n, _ := result.RowsAffected()
+		count += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrapf(err, "failed to commit %s transaction", t.Name())
+	}
+	return count, nil
+} //sqlTable.PruneContext()
+
+func (t *sqlTable) Sweep() (int, error) {
+	return t.SweepContext(context.Background())
+}
+
+func (t *sqlTable) SweepContext(ctx context.Context) (int, error) {
 	if t == nil {
-		return fmt.Errorf("nil.DelItem()")
+		return 0, fmt.Errorf("nil.Sweep()")
 	}
-	if old.Table() != t {
-		return fmt.Errorf("%s.DelItem(nid=%d,uid=%s) from other table=%s", t.Name(), old.NID(), old.UID(), old.Table().Name())
+	ttl := t.TTL()
+	queryStr := fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE NOT %s", revFields, t.csvFieldNames, t.curTableName, t.liveCondition())
+	rows, err := t.conn.QueryContext(ctx, queryStr)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get expired %s items with: %s", t.Name(), queryStr)
+	}
+	expired := make([]items.IItem, 0)
+	for rows.Next() {
+		item, err := t.scanItem(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, item)
+	}
+	rows.Close()
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	if ttl.Purge {
+		uids := ""
+		for _, item := range expired {
+			uids += fmt.Sprintf(",\"%s\"", escape(item.UID()))
+		}
+		return t.purge(ctx, fmt.Sprintf("uid IN (%s)", uids[1:]), "", true)
 	}
+	if items.RevInfoFrom(ctx).Reason == "" {
+		ctx = items.WithReason(ctx, items.ExpiredReason)
+	}
+	count := 0
+	for _, item := range expired {
+		if err := t.DelItemContext(ctx, items.DeletedItem(item)); err != nil {
+			var conflict *items.ErrRevisionConflict
+			if errors.As(err, &conflict) || errors.Is(err, items.ErrDeleted) {
+				continue //written in the meantime
+			}
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+} //sqlTable.SweepContext()
 
-	//mark as deleted by changing the last 3 digits of timestamp to be "DEL"
-	delTs := old.Rev().Timestamp().UTC().Format(revTsFormat)
-	delTs = delTs[0:14] + ".DEL"
+func (t *sqlTable) Purge(uid string) error {
+	return t.PurgeContext(context.Background(), uid)
+}
+
+func (t *sqlTable) PurgeContext(ctx context.Context, uid string) error {
+	if t == nil {
+		return fmt.Errorf("nil.Purge()")
+	}
+	_, err := t.purge(ctx, fmt.Sprintf("uid=\"%s\"", escape(uid)), uid, false)
+	return err
+}
+
+func (t *sqlTable) PurgeAll() error {
+	return t.PurgeAllContext(context.Background())
+}
+
+func (t *sqlTable) PurgeAllContext(ctx context.Context) error {
+	if t == nil {
+		return fmt.Errorf("nil.PurgeAll()")
+	}
+	_, err := t.purge(ctx, "1", "", false)
+	return err
+}
 
-	values, err := itemValueDef(old.Data())
+//purge removes all revisions of the items matching the condition, in a transaction that also records the purges
+//references to items that are not deleted are handled like when they are deleted
+//uid is specified to fail with ErrNotFound when there is no such item
+//when expired is true, items that are no longer expired in the transaction are kept
+//it returns how many items were purged
+func (t *sqlTable) purge(ctx context.Context, where string, uid string, expired bool) (int, error) {
+	//latest revision and nr of revisions of each item
+	type purged struct {
+		nid       int
+		lastRev   int
+		revisions int
+	}
+	list := make(map[string]purged)
+	queryStr := fmt.Sprintf("SELECT uid,MAX(nid),MAX(revNr),COUNT(*) FROM `%s` WHERE %s GROUP BY uid", t.tableName, where)
+	rows, err := t.conn.QueryContext(ctx, queryStr)
 	if err != nil {
-		return errors.Wrapf(err, "failed to define %s values for SQL", t.Name())
+		return 0, errors.Wrapf(err, "failed to get %s items to purge with: %s", t.Name(), queryStr)
+	}
+	for rows.Next() {
+		var uid string
+		var p purged
+		if err := rows.Scan(&uid, &p.nid, &p.lastRev, &p.revisions); err != nil {
+			rows.Close()
+			return 0, errors.Wrapf(err, "failed to parse %s item to purge", t.Name())
+		}
+		list[uid] = p
+	}
+	rows.Close()
+	if uid != "" && len(list) == 0 {
+		return 0, errors.Wrapf(items.ErrNotFound, "%s.uid=%s", t.Name(), uid)
+	}
+	if len(list) == 0 {
+		return 0, nil
 	}
 
-	//delete by inserting new record with next rev nr
-	//marked as deleted. It will fail if done with an old rev, not the latest
-	queryStr := fmt.Sprintf("INSERT INTO `%s` SET", t.tableName)
-	queryStr += fmt.Sprintf(" uid=\"%s\"", old.UID())
-	queryStr += fmt.Sprintf(",revNr=%d,revTs=\"%s\"", old.Rev().Nr(), delTs)
-	queryStr += "," + values
-	_, err = t.conn.Exec(queryStr)
+	live := make([]items.IItem, 0)
+	queryStr = fmt.Sprintf("SELECT %s,%s FROM `%s` WHERE %s", revFields, t.csvFieldNames, t.curTableName, where)
+	rows, err = t.conn.QueryContext(ctx, queryStr)
 	if err != nil {
-		return errors.Wrapf(err, "failed to mark %s as deleted with: %s", t.Name(), queryStr)
+		return 0, errors.Wrapf(err, "failed to get %s items to purge with: %s", t.Name(), queryStr)
+	}
+	for rows.Next() {
+		item, err := t.scanItem(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		live = append(live, item)
+	}
+	rows.Close()
+	referring, err := items.DelRefs(ctx, t, live...)
+	if err != nil {
+		return 0, err
 	}
-	return nil
-} //sqlTable.DelItem()
 
-func (t *sqlTable) DelAll() error {
-	if t == nil {
-		return fmt.Errorf("nil.DelAll()")
+	tx, err := t.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to start %s transaction", t.Name())
 	}
+	defer tx.Rollback()
+	curWhere := ""
+	if expired {
+		//lock the items that are still expired, so they are not written before they are purged
+		curWhere = " AND NOT " + t.liveCondition()
+		queryStr := fmt.Sprintf("SELECT uid FROM `%s` WHERE %s%s FOR UPDATE", t.curTableName, where, curWhere)
+		rows, err := tx.QueryContext(ctx, queryStr)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to get expired %s items with: %s", t.Name(), queryStr)
+		}
+		still := make(map[string]purged)
+		for rows.Next() {
+			var uid string
+			if err := rows.Scan(&uid); err != nil {
+				rows.Close()
+				return 0, errors.Wrapf(err, "failed to parse expired %s item", t.Name())
+			}
+			if p, ok := list[uid]; ok {
+				still[uid] = p
+			}
+		}
+		rows.Close()
+		if len(still) == 0 {
+			return 0, nil
+		}
+		list = still
+	}
+	records := make([]items.PurgeRecord, 0, len(list))
+	uids := ""
+	values := ""
+	for uid, p := range list {
+		record := items.NewPurgeRecord(ctx, t, uid, p.nid, p.revisions)
+		records = append(records, record)
+		uids += fmt.Sprintf(",\"%s\"", escape(uid))
+		values += fmt.Sprintf(",(\"%s\",%d,%d,\"%s\",\"%s\",\"%s\")", escape(uid), record.NID, record.Revisions,
+			record.Timestamp.UTC().Format(revTsFormat), escape(record.Actor), escape(record.Reason))
+	}
+	for _, queryStr := range []string{
+		fmt.Sprintf("DELETE FROM `%s` WHERE uid IN (%s)%s", t.curTableName, uids[1:], curWhere),
+		fmt.Sprintf("DELETE FROM `%s` WHERE uid IN (%s)", t.tableName, uids[1:]),
+		fmt.Sprintf("DELETE FROM `%s` WHERE uid IN (%s)", t.schedTableName, uids[1:]),
+		fmt.Sprintf("DELETE FROM `%s` WHERE uid IN (%s)", t.draftTableName, uids[1:]),
+		fmt.Sprintf("INSERT INTO `%s` (uid,nid,revisions,ts,actor,reason) VALUES %s", t.purgeTableName, values[1:]),
+	} {
+		if _, err := tx.ExecContext(ctx, queryStr); err != nil {
+			return 0, errors.Wrapf(err, "failed to purge %s with: %s", t.Name(), queryStr)
+		}
+	}
+	t.publishMutex.Lock()
+	if err := tx.Commit(); err != nil {
+		t.publishMutex.Unlock()
+		return 0, errors.Wrapf(err, "failed to commit %s transaction", t.Name())
+	}
+	for _, record := range records {
+		t.Feed().Purge(record.UID, list[record.UID].lastRev)
+	}
+	t.publishMutex.Unlock()
+	//scheduled revisions of the items were removed
+	if err := t.loadNextDue(ctx); err != nil {
+		return 0, err
+	}
+	return len(list), items.ApplyRefs(ctx, referring)
+} //sqlTable.purge()
 
-	//TODO: Does not preserve history - need to insert individuals to be complient!
-	queryStr := fmt.Sprintf("DELETE FROM `%s`", t.tableName)
-	_, err := t.conn.Exec(queryStr)
+func (t *sqlTable) Purges() ([]items.PurgeRecord, error) {
+	return t.PurgesContext(context.Background())
+}
+
+func (t *sqlTable) PurgesContext(ctx context.Context) ([]items.PurgeRecord, error) {
+	queryStr := fmt.Sprintf("SELECT uid,nid,revisions,ts,actor,reason FROM `%s` ORDER BY id", t.purgeTableName)
+	rows, err := t.conn.QueryContext(ctx, queryStr)
 	if err != nil {
-		return errors.Wrapf(err, "failed to deleted all from %s", t.Name())
+		return nil, errors.Wrapf(err, "failed to get %s purges with: %s", t.Name(), queryStr)
 	}
-	return nil
+	defer rows.Close()
+	list := make([]items.PurgeRecord, 0)
+	for rows.Next() {
+		var record items.PurgeRecord
+		if err := rows.Scan(&record.UID, &record.NID, &record.Revisions, &record.Timestamp, &record.Actor, &record.Reason); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s purge", t.Name())
+		}
+		list = append(list, record)
+	}
+	return list, nil
 }
 
 func (t *sqlTable) Index(name string, fieldNames []string) (items.IIndex, error) {
-	//for now just return because mysql will find on any field without an index
-	//but this must be created soon to improve performance on large tables
-	//todo!
+	return t.IndexContext(context.Background(), name, fieldNames)
+}
+
+//IndexContext also adds a unique key idx_<name> for the index to the current table
+//so that writes fail when another item has the key
+func (t *sqlTable) IndexContext(ctx context.Context, name string, fieldNames []string) (items.IIndex, error) {
 	newIndex, err := items.NewIndex(t, name, fieldNames)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to describe index")
@@ -219,12 +1380,44 @@ func (t *sqlTable) Index(name string, fieldNames []string) (items.IIndex, error)
 	//add the index to the table
 	si := &sqlIndex{
 		IIndex: newIndex,
+		table:  t,
 	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.index[name]; ok {
+		return nil, fmt.Errorf("Duplicate db.Table(%s).Index(%s)", t.Name(), name)
+	}
+	var n int
+	keyName := "idx_" + name
+	queryStr := fmt.Sprintf("SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=\"%s\" AND INDEX_NAME=\"%s\"",
+		escape(t.curTableName), escape(keyName))
+	if err := t.conn.QueryRowContext(ctx, queryStr).Scan(&n); err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s indexes with: %s", t.Name(), queryStr)
+	}
+	if n == 0 {
+		queryStr = fmt.Sprintf("ALTER TABLE `%s` ADD UNIQUE KEY `%s` (%s)", t.curTableName, keyName, strings.Join(fieldNames, ","))
+		if _, err := t.conn.ExecContext(ctx, queryStr); err != nil {
+			return nil, errors.Wrapf(err, "failed to add unique key for %s.%s with: %s", t.Name(), name, queryStr)
+		}
+	}
+	t.index[name] = si
 	return si, nil
+} //sqlTable.IndexContext()
+
+func (t *sqlTable) GetIndex(name string) items.IIndex {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if index, ok := t.index[name]; ok {
+		return index
+	}
+	return nil
 }
 
-func itemValueDef(i interface{}) (string, error) {
-	//log.Debugf("itemValueDef(%T)", i)
+//itemValueList returns the SQL values of the item fields in CSV
+//in the same order as StructFields
+func itemValueList(i interface{}) (string, error) {
+	//log.Debugf("itemValueList(%T)", i)
 	t := reflect.TypeOf(i)
 	v := reflect.ValueOf(i)
 	for t.Kind() == reflect.Ptr {
@@ -232,10 +1425,10 @@ func itemValueDef(i interface{}) (string, error) {
 		v = v.Elem()
 	}
 	if t.Kind() != reflect.Struct {
-		return "", fmt.Errorf("itemValueDef(%T) is not a struct", i)
+		return "", fmt.Errorf("itemValueList(%T) is not a struct", i)
 	}
 
-	valueDef := ""
+	valueList := ""
 	//log.Debugf("  %T has %d fields", i, v.NumField())
 	for fieldIndex := 0; fieldIndex < v.NumField(); fieldIndex++ {
 		fieldValue := v.Field(fieldIndex)
@@ -245,38 +1438,46 @@ func itemValueDef(i interface{}) (string, error) {
 			continue
 		}
 		//log.Debugf("Field[%d]: %+v", fieldIndex, fieldValue)
-
-		switch fieldType.Type.Kind() {
-		case reflect.Int, reflect.Float32, reflect.Float64, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			//numeric values are not quoted:
-			valueDef += fmt.Sprintf(",%s=%v", fieldType.Name, fieldValue.Interface())
-		case reflect.Struct:
-			switch fieldType.Type {
-			case reflect.TypeOf(time.Time{}):
-				//time value format
-				valueDef += fmt.Sprintf(",%s=\"%s\"", fieldType.Name, fieldValue.Interface().(time.Time).UTC().Format("2006-01-02 15:04:05.000000"))
-			default:
-				//default to some quoted value
-				//consider encoding JSON here for structs
-				valueStr := fmt.Sprintf("%v", fieldValue.Interface())
-				valueDef += fmt.Sprintf(",%s=\"%s\"", fieldType.Name, escape(valueStr))
-			}
-		default:
-			//default to some quoted value
-			valueStr := fmt.Sprintf("%v", fieldValue.Interface())
-			valueDef += fmt.Sprintf(",%s=\"%v\"", fieldType.Name, escape(valueStr))
-		}
+		valueList += "," + sqlValue(fieldValue)
 	}
 
-	if len(valueDef) == 0 {
-		//log.Debugf("itemValueDef(%T) -> \"\"", i)
+	if len(valueList) == 0 {
+		//log.Debugf("itemValueList(%T) -> \"\"", i)
 		return "", nil
 	}
 
-	//log.Debugf("itemValueDef(%T) -> %s", i, valueDef[1:])
-	return valueDef[1:], nil
+	//log.Debugf("itemValueList(%T) -> %s", i, valueList[1:])
+	return valueList[1:], nil
 }
 
+//sqlValue formats a field value for SQL statements by its kind, to write it and to compare it
+func sqlValue(fieldValue reflect.Value) string {
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Float32, reflect.Float64, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		//numeric values are not quoted:
+		return fmt.Sprintf("%v", fieldValue.Interface())
+	case reflect.Bool:
+		//TRUE or FALSE is 1 or 0 in the column
+		return fmt.Sprintf("%t", fieldValue.Bool())
+	case reflect.Struct:
+		switch fieldValue.Type() {
+		case reflect.TypeOf(time.Time{}):
+			//time value format
+			return fmt.Sprintf("\"%s\"", fieldValue.Interface().(time.Time).UTC().Format(revTsFormat))
+		default:
+			//default to some quoted value
+			//consider encoding JSON here for structs
+			valueStr := fmt.Sprintf("%v", fieldValue.Interface())
+			return fmt.Sprintf("\"%s\"", escape(valueStr))
+		}
+	default:
+		//default to some quoted value
+		valueStr := fmt.Sprintf("%v", fieldValue.Interface())
+		return fmt.Sprintf("\"%v\"", escape(valueStr))
+	}
+} //sqlValue()
+
 //escape is elementary assuming mysql - need to extend to consider other SQL drivers
 //e.g. PostgreSQL and Microsoft etc...
 func escape(source string) string {
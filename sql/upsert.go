@@ -0,0 +1,170 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jansemmelink/items"
+	"github.com/pkg/errors"
+)
+
+func (t *sqlTable) Upsert(indexName string, data items.IData) (items.IItem, bool, error) {
+	return t.UpsertContext(context.Background(), indexName, data)
+}
+
+func (t *sqlTable) UpsertContext(ctx context.Context, indexName string, data items.IData) (items.IItem, bool, error) {
+	return t.upsert(ctx, indexName, data, true)
+}
+
+func (t *sqlTable) GetOrCreate(indexName string, data items.IData) (items.IItem, bool, error) {
+	return t.GetOrCreateContext(context.Background(), indexName, data)
+}
+
+func (t *sqlTable) GetOrCreateContext(ctx context.Context, indexName string, data items.IData) (items.IItem, bool, error) {
+	return t.upsert(ctx, indexName, data, false)
+}
+
+//errKeyChanged is the reason for upsert() to find the item with the key again
+var errKeyChanged = errors.New("key written in the meantime")
+
+//upsert finds the item with the key of data in the index and updates it (when update is true) or returns it,
+//else it adds data as a new item and returns true
+//the revision is prepared outside the transaction, because the hooks may read the table, and then written
+//in a transaction that locks the key in the unique key of the index (see IndexContext()), only if the key
+//still belongs to the same item (or none), else it is prepared again for the item that has the key now,
+//according to the retry policy of the table
+func (t *sqlTable) upsert(ctx context.Context, indexName string, data items.IData, update bool) (items.IItem, bool, error) {
+	if t == nil {
+		return nil, false, fmt.Errorf("nil.Upsert()")
+	}
+	if data == nil {
+		return nil, false, fmt.Errorf("%s.Upsert(%s,nil)", t.Name(), indexName)
+	}
+	index := t.GetIndex(indexName)
+	if index == nil {
+		return nil, false, fmt.Errorf("table %s does not have index %s", t.Name(), indexName)
+	}
+	dataValue := reflect.ValueOf(data)
+	key := make(map[string]interface{})
+	for _, fieldName := range index.Fields() {
+		key[fieldName] = dataValue.FieldByName(fieldName).Interface()
+	}
+	retry := t.Retry()
+	if retry.Attempts <= 0 {
+		retry = items.DefaultRetry
+	}
+
+	backoff := retry.Backoff
+	for attempt := 1; ; attempt++ {
+		item, created, again, err := t.upsertOnce(ctx, index, key, data, update)
+		if !again {
+			return item, created, err
+		}
+		if attempt >= retry.Attempts {
+			return nil, false, &items.ErrRetriesExhausted{Table: t.Name(), UID: index.MapKey(key).String(), Attempts: attempt, Err: err}
+		}
+
+		//wait before finding the item again
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+} //sqlTable.upsert()
+
+//upsertOnce is one attempt of upsert(), which returns again=true with the reason in err
+//when the item with the key was written in the meantime
+func (t *sqlTable) upsertOnce(ctx context.Context, index items.IIndex, key map[string]interface{}, data items.IData, update bool) (items.IItem, bool, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, false, err
+	}
+	cur, err := index.FindOneContext(ctx, key)
+	if err != nil {
+		return nil, false, false, err
+	}
+	if cur != nil && !update {
+		return cur, false, false, nil
+	}
+
+	var next items.IItem
+	var referring []items.Referring
+	if cur == nil {
+		next, err = t.prepareAdd(ctx, data)
+	} else if _, next, err = t.prepareUpd(ctx, items.NextItem(cur, data)); err == nil {
+		referring, err = items.UpdRefs(ctx, t, []items.IItem{cur}, []items.IItem{next})
+	}
+	var conflict *items.ErrRevisionConflict
+	var duplicate *items.ErrDuplicateKey
+	if errors.As(err, &conflict) || errors.Is(err, items.ErrDeleted) || errors.Is(err, items.ErrNotFound) ||
+		(errors.As(err, &duplicate) && duplicate.Index == index.Name()) {
+		return nil, false, true, err
+	}
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	written, err := t.writeKey(ctx, index, data, cur, next)
+	if err != nil {
+		return nil, false, errors.Is(err, errKeyChanged), err
+	}
+	if cur == nil {
+		t.Hooks().After(items.Added, nil, written)
+		return written, true, false, nil
+	}
+	t.Hooks().After(items.Updated, cur, written)
+	return written, false, false, items.ApplyRefs(ctx, referring)
+} //sqlTable.upsertOnce()
+
+//writeKey writes next in a transaction that locks the key of data in the index,
+//if the key still belongs to cur, or to no item when cur is nil
+//it fails with errKeyChanged without writing when the key changed or another transaction took it at the same time
+func (t *sqlTable) writeKey(ctx context.Context, index items.IIndex, data items.IData, cur items.IItem, next items.IItem) (items.IItem, error) {
+	tx, err := t.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start %s transaction", t.Name())
+	}
+	defer tx.Rollback()
+
+	//the lock on the key in the unique key also blocks other inserts of the key until the commit
+	queryStr := fmt.Sprintf("SELECT uid,revNr FROM `%s` WHERE %s FOR UPDATE", t.curTableName, keyCondition(index, data))
+	rows, err := tx.QueryContext(ctx, queryStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to lock %s.%s with: %s", t.Name(), index.Name(), queryStr)
+	}
+	holder, holderRev := "", 0
+	if rows.Next() {
+		if err := rows.Scan(&holder, &holderRev); err != nil {
+			rows.Close()
+			return nil, errors.Wrapf(err, "failed to parse %s.%s", t.Name(), index.Name())
+		}
+	}
+	rows.Close()
+	if (cur == nil && holder != "") || (cur != nil && (holder != cur.UID() || holderRev != cur.Rev().Nr())) {
+		return nil, errors.Wrapf(errKeyChanged, "%s.%s", t.Name(), index.Name())
+	}
+
+	written, errs, err := t.insertTx(ctx, tx, []items.IItem{next})
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1213 { //ER_LOCK_DEADLOCK with another writer of the key
+		return nil, errors.Wrapf(errKeyChanged, "%s.%s: %v", t.Name(), index.Name(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		var duplicate *items.ErrDuplicateKey
+		if errors.As(errs[0], &duplicate) && duplicate.Index == index.Name() {
+			return nil, errors.Wrapf(errKeyChanged, "%s", errs[0])
+		}
+		return nil, errs[0]
+	}
+	if err := t.commit(tx, []items.IItem{cur}, written); err != nil {
+		return nil, err
+	}
+	return written[0], nil
+} //sqlTable.writeKey()
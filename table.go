@@ -1,38 +1,225 @@
 package items
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sync"
+	"time"
 )
 
 //ITable of items with the same structure
+//operations that take a context.Context fail when it is cancelled or its deadline expires,
+//the variants without a context use context.Background()
 type ITable interface {
 	//table description
+	Db() IDb
 	Name() string
 	Type() reflect.Type
 	Schema() ISchema
 	Count() int
+	CountContext(ctx context.Context) (int, error)
 
 	//add a new item (with rev 1) to the table
+	//it fails with ErrDuplicateKey or ErrInvalidData like UpdItem
 	AddItem(data IData) (IItem, error)
+	AddItemContext(ctx context.Context, data IData) (IItem, error)
 
 	//upd will fail if item does not exist already with specified item.rev-1
 	//it returns upd,nil on success, or nil,err if cannot update
+	//with ErrRevisionConflict if the item is no longer at item.rev-1,
+	//ErrDuplicateKey if the data conflicts with another item in an index,
+	//or ErrInvalidData if the data is not valid
 	UpdItem(upd IItem) (IItem, error)
+	UpdItemContext(ctx context.Context, upd IItem) (IItem, error)
+
+	//write a batch of items as one unit: all entries are written, or none of them
+	//it returns the written revisions in the same order,
+	//or *BatchError with the entries that failed
+	//UpdItems() expects next revisions from NextItem() and DelItems() deleted revisions from DeletedItem()
+	AddItems(list []IData) ([]IItem, error)
+	AddItemsContext(ctx context.Context, list []IData) ([]IItem, error)
+	UpdItems(list []IItem) ([]IItem, error)
+	UpdItemsContext(ctx context.Context, list []IItem) ([]IItem, error)
+	DelItems(list []IItem) error
+	DelItemsContext(ctx context.Context, list []IItem) error
+
+	//get, modify and update an item, getting it again and retrying the modification
+	//when it was written by someone else in the meantime, according to the retry policy
+	//it fails with ErrRetriesExhausted when all attempts had revision conflicts
+	Modify(uid string, fn ModifyFunc) (IItem, error)
+	ModifyContext(ctx context.Context, uid string, fn ModifyFunc) (IItem, error)
+
+	//update the item with the key of data in the named index with data, or add data as a new item if there is no such item
+	//it returns true when the item was created, and is atomic with respect to the key of the index:
+	//the item is written only if the key still belongs to the item that was found (or to none), checked under
+	//the table lock in mem and in the transaction that locks the key in SQL, else it finds the item with the key again
+	//it retries according to the retry policy and fails with ErrRetriesExhausted after the last attempt
+	Upsert(indexName string, data IData) (IItem, bool, error)
+	UpsertContext(ctx context.Context, indexName string, data IData) (IItem, bool, error)
+	//get the item with the key of data in the named index, or add data as a new item if there is no such item
+	//it returns true when the item was created, and is atomic like Upsert()
+	GetOrCreate(indexName string, data IData) (IItem, bool, error)
+	GetOrCreateContext(ctx context.Context, indexName string, data IData) (IItem, bool, error)
+
+	//update an item with new values for some of its fields
+	//the values are converted to the field types (see ConvertValue()) and applied to a copy of the item data,
+	//which is then written as the next revision
+	//it fails with ErrInvalidData for unknown fields or values that cannot be converted
+	Patch(item IItem, fields map[string]interface{}) (IItem, error)
+	PatchContext(ctx context.Context, item IItem, fields map[string]interface{}) (IItem, error)
+
+	//restore a deleted item, writing its data before it was deleted as the revision after the deleted revision
+	//it fails with ErrNotFound if the item does not exist, and fails if the item is not deleted
+	//it runs the add hooks and is published as Added, with the restored item at its next revision nr
+	Restore(uid string) (IItem, error)
+	RestoreContext(ctx context.Context, uid string) (IItem, error)
+
+	//revert an item to the data of an earlier revision, by writing it as the next revision
+	//it fails with ErrNotFound if the revision does not exist (anymore), ErrDeleted if the item is deleted,
+	//or ErrRevisionConflict if the item was written by someone else in the meantime
+	Revert(uid string, revNr int) (IItem, error)
+	RevertContext(ctx context.Context, uid string, revNr int) (IItem, error)
+
+	//schedule the next revision of an item from NextItem() to be written at a later time, e.g. a price change next week
+	//the item keeps its current revision until then, and the scheduled revision is written by the first read of the table
+	//at or after that time, with at as its timestamp, so it is published to the feed only when it is written
+	//it is written only if the item is still at the revision before it, so when the item is written in the meantime,
+	//the scheduled revision fails with a revision conflict and is kept with the failure in Scheduled() until cancelled
+	//it fails with ErrRevisionConflict if the item is no longer at upd.rev-1, or ErrInvalidData if the data is not valid
+	Schedule(upd IItem, at time.Time) (ScheduledRev, error)
+	ScheduleContext(ctx context.Context, upd IItem, at time.Time) (ScheduledRev, error)
+	//list the scheduled revisions that are pending or failed, by time
+	Scheduled() ([]ScheduledRev, error)
+	ScheduledContext(ctx context.Context) ([]ScheduledRev, error)
+	//cancel a pending or failed scheduled revision, it fails with ErrNotFound if there is no such revision
+	CancelScheduled(id int) error
+	CancelScheduledContext(ctx context.Context, id int) error
+
+	//propose the next revision of an item from NextItem() as a draft, which is written only when it is approved
+	//it fails with ErrNoActor without an actor in the context, see WithActor(),
+	//ErrRevisionConflict if the item is no longer at upd.rev-1, or ErrInvalidData if the data is not valid
+	Propose(upd IItem) (Draft, error)
+	ProposeContext(ctx context.Context, upd IItem) (Draft, error)
+	//list the drafts that are not approved or rejected yet, oldest first
+	Drafts() ([]Draft, error)
+	DraftsContext(ctx context.Context) ([]Draft, error)
+	//approve a draft by another actor than the one who proposed it, see WithActor(), writing it as the next revision
+	//with the info of the proposal and the approver in its ApprovedByAnnotation
+	//it fails with ErrNotFound if there is no such draft, ErrNoActor, ErrSelfApproval, or ErrRevisionConflict
+	//if the item was written after the draft was proposed, which keeps the draft until it is rejected
+	Approve(id int) (IItem, error)
+	ApproveContext(ctx context.Context, id int) (IItem, error)
+	//reject a draft, it fails with ErrNotFound if there is no such draft
+	Reject(id int) error
+	RejectContext(ctx context.Context, id int) error
+
+	//set the retry policy of Modify() and Upsert()
+	SetRetry(r Retry)
+	Retry() Retry
 
 	//get the latest revision of the specified item
-	GetItem(uid string) IItem
+	//it fails with ErrNotFound if the item does not exist, or ErrDeleted if it was deleted
+	GetItem(uid string) (IItem, error)
+	GetItemContext(ctx context.Context, uid string) (IItem, error)
+
+	//get the latest revision of the item with the nid, like GetItem()
+	//the nid is assigned when the item is added and kept in all its revisions
+	GetItemByNID(nid int) (IItem, error)
+	GetItemByNIDContext(ctx context.Context, nid int) (IItem, error)
+
+	//get the revision of the specified item as known at knownAt and effective at validAt:
+	//the latest revision with a timestamp at or before knownAt of which the valid time includes validAt,
+	//see WithValidTime(), while GetItem() gets the latest revision regardless of its valid time
+	//it fails with ErrNotFound if there is no such revision, or ErrDeleted if it is a deleted revision
+	GetItemAsOf(uid string, knownAt time.Time, validAt time.Time) (IItem, error)
+	GetItemAsOfContext(ctx context.Context, uid string, knownAt time.Time, validAt time.Time) (IItem, error)
+
+	//get all items as known at knownAt and effective at validAt with uid as map index, see GetItemAsOf()
+	ItemsAsOf(knownAt time.Time, validAt time.Time) (map[string]IItem, error)
+	ItemsAsOfContext(ctx context.Context, knownAt time.Time, validAt time.Time) (map[string]IItem, error)
 
 	//delete all revisions of the specified item (fail if not the latest revision anymore)
 	DelItem(i IItem) error
+	DelItemContext(ctx context.Context, i IItem) error
 
 	//get a list of all items at their current latest revision with uid as map index
 	Items() map[string]IItem
+	ItemsContext(ctx context.Context) (map[string]IItem, error)
+
+	//get the items of which the field has the value, at their current latest revision with uid as map index,
+	//e.g. the items that refer to an item, which is looked up by the field without getting all items
+	ItemsWith(field string, value interface{}) (map[string]IItem, error)
+	ItemsWithContext(ctx context.Context, field string, value interface{}) (map[string]IItem, error)
 
-	//delete all entries (currently: without keeping revisions, so complete wipe)
+	//delete all items, writing a deleted revision for each item as one unit like DelItems()
+	//the history of the items is kept, use PurgeAll() to remove it
 	DelAll() error
+	DelAllContext(ctx context.Context) error
+
+	//remove all revisions of an item, also when it was deleted, e.g. for a legal erasure request
+	//unlike DelItem() this cannot be undone, but the purge is recorded without the item data, see Purges()
+	//it fails with ErrNotFound if the table has no revisions of the item
+	Purge(uid string) error
+	PurgeContext(ctx context.Context, uid string) error
+	//remove all revisions of all items, see Purge()
+	PurgeAll() error
+	PurgeAllContext(ctx context.Context) error
+	//list the purges of items in the table, oldest first
+	Purges() ([]PurgeRecord, error)
+	PurgesContext(ctx context.Context) ([]PurgeRecord, error)
 
 	Index(name string, fields []string) (IIndex, error)
+	IndexContext(ctx context.Context, name string, fields []string) (IIndex, error)
+
+	//get an index previously defined with Index(), nil if not defined
+	GetIndex(name string) IIndex
+
+	//get all revisions of the specified item, oldest first
+	//including the revision that deleted it, if it was deleted
+	History(uid string) ([]IItem, error)
+	HistoryContext(ctx context.Context, uid string) ([]IItem, error)
+
+	//watch the changes written to the table after position from (0 for only new changes)
+	//see Feed.Watch()
+	Watch(ctx context.Context, from uint64) (<-chan Change, error)
+
+	//feed that implementations publish their writes to
+	Feed() *Feed
+
+	//hooks called before and after writes
+	Hooks() *Hooks
+
+	//declare that field refers to an item in the target table of the same db,
+	//either by uid (targetIndex="") or by the named single field index,
+	//with onDel determining what happens when the target item is deleted, or updated with another value in the index,
+	//which is applied to the referring items after the target item is written
+	Ref(field string, target ITable, targetIndex string, onDel RefAction) error
+
+	//list the refs declared from this table
+	Refs() []Ref
+
+	//list the refs declared from any table to this table
+	RefsTo() []Ref
+
+	//set the policy that Prune() applies to the revisions in the table
+	SetRetention(r Retention)
+	Retention() Retention
+
+	//remove revisions according to the retention policy
+	//and return the number of revisions removed
+	Prune() (int, error)
+	PruneContext(ctx context.Context) (int, error)
+
+	//set the time-to-live of the items in the table
+	SetTTL(ttl TTL)
+	TTL() TTL
+
+	//write deleted revisions for the items that expired, or purge them, see TTL,
+	//and return the number of items removed
+	//the deleted revisions are written like DelItem(), with ExpiredReason
+	Sweep() (int, error)
+	SweepContext(ctx context.Context) (int, error)
 }
 
 //table implements ITable
@@ -41,6 +228,20 @@ type table struct {
 	name       string
 	structType reflect.Type
 	schema     ISchema
+	feed       *Feed
+	hooks      *Hooks
+	refs       *refs
+	mutex      sync.Mutex
+	retention  Retention
+	retry      Retry
+	ttl        TTL
+}
+
+func (t *table) Db() IDb {
+	if t == nil {
+		panic("nil.Db()")
+	}
+	return t.db
 }
 
 func (t *table) Name() string {
@@ -65,18 +266,74 @@ func (t *table) Schema() ISchema {
 }
 
 func (t *table) AddItem(data IData) (IItem, error) {
+	return t.AddItemContext(context.Background(), data)
+}
+
+func (t *table) AddItemContext(ctx context.Context, data IData) (IItem, error) {
 	return nil, fmt.Errorf("db(%s).table(%s).AddItem() not implemented", t.db.Name(), t.name)
 }
 
 func (t *table) UpdItem(upd IItem) (IItem, error) {
+	return t.UpdItemContext(context.Background(), upd)
+}
+
+func (t *table) UpdItemContext(ctx context.Context, upd IItem) (IItem, error) {
 	return nil, fmt.Errorf("db(%s).table(%s).UpdItem() not implemented", t.db.Name(), t.name)
 }
 
-func (t *table) GetItem(uid string) IItem {
-	return nil //, fmt.Errorf("db(%s).table(%s).GetItem() not implemented", t.db.Name(), t.name)
+func (t *table) AddItems(list []IData) ([]IItem, error) {
+	return t.AddItemsContext(context.Background(), list)
+}
+
+func (t *table) AddItemsContext(ctx context.Context, list []IData) ([]IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).AddItems() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) UpdItems(list []IItem) ([]IItem, error) {
+	return t.UpdItemsContext(context.Background(), list)
+}
+
+func (t *table) UpdItemsContext(ctx context.Context, list []IItem) ([]IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).UpdItems() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) DelItems(list []IItem) error {
+	return t.DelItemsContext(context.Background(), list)
+}
+
+func (t *table) DelItemsContext(ctx context.Context, list []IItem) error {
+	return fmt.Errorf("db(%s).table(%s).DelItems() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) GetItem(uid string) (IItem, error) {
+	return t.GetItemContext(context.Background(), uid)
+}
+
+func (t *table) GetItemContext(ctx context.Context, uid string) (IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).GetItem() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) Restore(uid string) (IItem, error) {
+	return t.RestoreContext(context.Background(), uid)
+}
+
+func (t *table) RestoreContext(ctx context.Context, uid string) (IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).Restore() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) GetItemByNID(nid int) (IItem, error) {
+	return t.GetItemByNIDContext(context.Background(), nid)
+}
+
+func (t *table) GetItemByNIDContext(ctx context.Context, nid int) (IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).GetItemByNID() not implemented", t.db.Name(), t.name)
 }
 
 func (t *table) DelItem(old IItem) error {
+	return t.DelItemContext(context.Background(), old)
+}
+
+func (t *table) DelItemContext(ctx context.Context, old IItem) error {
 	return fmt.Errorf("db(%s).table(%s).DelItem() not implemented", t.db.Name(), t.name)
 }
 
@@ -84,14 +341,128 @@ func (t *table) Items() map[string]IItem {
 	return make(map[string]IItem)
 }
 
+func (t *table) ItemsContext(ctx context.Context) (map[string]IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).Items() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) ItemsWith(field string, value interface{}) (map[string]IItem, error) {
+	return t.ItemsWithContext(context.Background(), field, value)
+}
+
+func (t *table) ItemsWithContext(ctx context.Context, field string, value interface{}) (map[string]IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).ItemsWith() not implemented", t.db.Name(), t.name)
+}
+
 func (t *table) DelAll() error {
-	return fmt.Errorf("db(%s).table(%s).DelAll() not implemented", t.db.Name(), t.name)
+	return t.DelAllContext(context.Background())
+}
+
+func (t *table) DelAllContext(ctx context.Context) error {
+	//use the table as registered in the db, which wraps this table
+	self := t.db.GetTable(t.name)
+	if self == nil {
+		return fmt.Errorf("db(%s).table(%s) not found", t.db.Name(), t.name)
+	}
+	all, err := self.ItemsContext(ctx)
+	if err != nil {
+		return err
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	list := make([]IItem, 0, len(all))
+	for _, item := range all {
+		list = append(list, DeletedItem(item))
+	}
+	return self.DelItemsContext(ctx, list)
 }
 
 func (t *table) Index(name string, fields []string) (IIndex, error) {
+	return t.IndexContext(context.Background(), name, fields)
+}
+
+func (t *table) IndexContext(ctx context.Context, name string, fields []string) (IIndex, error) {
 	return nil, fmt.Errorf("db(%s).table(%T:%s).Index() not implemented", t.db.Name(), t, t.name)
 }
 
+func (t *table) GetIndex(name string) IIndex {
+	return nil
+}
+
+func (t *table) History(uid string) ([]IItem, error) {
+	return t.HistoryContext(context.Background(), uid)
+}
+
+func (t *table) HistoryContext(ctx context.Context, uid string) ([]IItem, error) {
+	return nil, fmt.Errorf("db(%s).table(%s).History() not implemented", t.db.Name(), t.name)
+}
+
+func (t *table) Watch(ctx context.Context, from uint64) (<-chan Change, error) {
+	return t.feed.Watch(ctx, from)
+}
+
+func (t *table) Feed() *Feed {
+	return t.feed
+}
+
+func (t *table) Hooks() *Hooks {
+	return t.hooks
+}
+
+func (t *table) Ref(field string, target ITable, targetIndex string, onDel RefAction) error {
+	//refer to the table as registered in the db, which wraps this table
+	self := t.db.GetTable(t.name)
+	if self == nil {
+		return fmt.Errorf("db(%s).table(%s) not found", t.db.Name(), t.name)
+	}
+	ref, err := newRef(t.db, self, field, target, targetIndex, onDel)
+	if err != nil {
+		return err
+	}
+	t.refs.add(ref)
+	return nil
+}
+
+func (t *table) Refs() []Ref {
+	return t.refs.find(t.name, "")
+}
+
+func (t *table) RefsTo() []Ref {
+	return t.refs.find("", t.name)
+}
+
+func (t *table) SetRetention(r Retention) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.retention = r
+}
+
+func (t *table) Retention() Retention {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.retention
+}
+
+func (t *table) SetRetry(r Retry) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.retry = r
+}
+
+func (t *table) Retry() Retry {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.retry
+}
+
+func (t *table) Prune() (int, error) {
+	return t.PruneContext(context.Background())
+}
+
+func (t *table) PruneContext(ctx context.Context) (int, error) {
+	return 0, fmt.Errorf("db(%s).table(%s).Prune() not implemented", t.db.Name(), t.name)
+}
+
 func (t *table) Count() int {
 	if t == nil {
 		panic("nil.Count()")
@@ -99,3 +470,7 @@ func (t *table) Count() int {
 	return -1
 	//return len(t.items)
 }
+
+func (t *table) CountContext(ctx context.Context) (int, error) {
+	return 0, fmt.Errorf("db(%s).table(%s).Count() not implemented", t.db.Name(), t.name)
+}
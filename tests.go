@@ -1,7 +1,11 @@
 package items
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/jansemmelink/log"
 	"github.com/pkg/errors"
@@ -20,6 +24,74 @@ func RunDbTests(db IDb) error {
 		return errors.Wrapf(err, "twofield test failed")
 	}
 
+	if err := historyTest(db); err != nil {
+		return errors.Wrapf(err, "history test failed")
+	}
+
+	if err := watchTest(db); err != nil {
+		return errors.Wrapf(err, "watch test failed")
+	}
+
+	if err := hooksTest(db); err != nil {
+		return errors.Wrapf(err, "hooks test failed")
+	}
+
+	if err := refsTest(db); err != nil {
+		return errors.Wrapf(err, "refs test failed")
+	}
+
+	if err := retentionTest(db); err != nil {
+		return errors.Wrapf(err, "retention test failed")
+	}
+	if err := contextTest(db); err != nil {
+		return errors.Wrapf(err, "context test failed")
+	}
+	if err := errorsTest(db); err != nil {
+		return errors.Wrapf(err, "errors test failed")
+	}
+	if err := typedTest(db); err != nil {
+		return errors.Wrapf(err, "typed test failed")
+	}
+	if err := batchTest(db); err != nil {
+		return errors.Wrapf(err, "batch test failed")
+	}
+	if err := modifyTest(db); err != nil {
+		return errors.Wrapf(err, "modify test failed")
+	}
+	if err := patchTest(db); err != nil {
+		return errors.Wrapf(err, "patch test failed")
+	}
+	if err := diffTest(db); err != nil {
+		return errors.Wrapf(err, "diff test failed")
+	}
+	if err := revInfoTest(db); err != nil {
+		return errors.Wrapf(err, "rev info test failed")
+	}
+	if err := createdTest(db); err != nil {
+		return errors.Wrapf(err, "created test failed")
+	}
+	if err := nidTest(db); err != nil {
+		return errors.Wrapf(err, "nid test failed")
+	}
+	if err := currentTest(db); err != nil {
+		return errors.Wrapf(err, "current test failed")
+	}
+	if err := restoreTest(db); err != nil {
+		return errors.Wrapf(err, "restore test failed")
+	}
+	if err := purgeTest(db); err != nil {
+		return errors.Wrapf(err, "purge test failed")
+	}
+	if err := validTimeTest(db); err != nil {
+		return errors.Wrapf(err, "valid time test failed")
+	}
+	if err := draftTest(db); err != nil {
+		return errors.Wrapf(err, "draft test failed")
+	}
+	if err := upsertTest(db); err != nil {
+		return errors.Wrapf(err, "upsert test failed")
+	}
+
 	return nil
 }
 
@@ -41,7 +113,7 @@ func longTest(db IDb) error {
 		return fmt.Errorf("managed to add dup table")
 	}
 
-	users.DelAll()
+	users.PurgeAll()
 
 	uni, err := users.Index("username", []string{"Name"})
 	if err != nil {
@@ -52,9 +124,9 @@ func longTest(db IDb) error {
 	if err != nil {
 		return errors.Wrapf(err, "Failed to add user one")
 	}
-	gotU1 := users.GetItem(u1.UID())
-	if gotU1 == nil {
-		return fmt.Errorf("Failed to get one")
+	gotU1, err := users.GetItem(u1.UID())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get one")
 	}
 	if users.Count() != 1 {
 		return fmt.Errorf("users.Count=%d", users.Count())
@@ -64,9 +136,9 @@ func longTest(db IDb) error {
 	if err != nil {
 		return errors.Wrapf(err, "Failed to add user two")
 	}
-	gotU2 := users.GetItem(u2.UID())
-	if gotU2 == nil {
-		return fmt.Errorf("Failed to get two")
+	gotU2, err := users.GetItem(u2.UID())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get two")
 	}
 
 	if gotU1.Rev().Nr() != 1 || gotU2.Rev().Nr() != 1 {
@@ -90,9 +162,9 @@ func longTest(db IDb) error {
 		return fmt.Errorf("Rev=%d after update", u1.Rev().Nr())
 	}
 
-	gotU1x := users.GetItem(u1.UID())
-	if gotU1x == nil {
-		return fmt.Errorf("Failed to get ONE")
+	gotU1x, err := users.GetItem(u1.UID())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get ONE")
 	}
 	if gotU1x.NID() != u1.NID() || gotU1x.UID() != u1.UID() {
 		return fmt.Errorf("Wrong ids %d!=%d or %s!=%s", gotU1x.NID(), u1.NID(), gotU1x.UID(), u1.UID())
@@ -124,9 +196,9 @@ func longTest(db IDb) error {
 	}
 
 	//after del, get should fail:
-	gotU1y := users.GetItem(u1.UID())
-	if gotU1y != nil {
-		return fmt.Errorf("Got u1 after deletion")
+	gotU1y, err := users.GetItem(u1.UID())
+	if gotU1y != nil || !errors.Is(err, ErrDeleted) {
+		return fmt.Errorf("Got u1 after deletion: %v", err)
 	}
 	log.Debugf("Good, failed to get u1 after delete")
 
@@ -145,20 +217,32 @@ func (u user) Validate() error {
 }
 
 type session struct {
-	sid   string
-	uname string
+	Sid   string
+	Uname string
 }
 
 func (s session) Validate() error {
-	if len(s.sid) < 1 {
+	if len(s.Sid) < 1 {
 		return fmt.Errorf("missing session.sid")
 	}
-	if len(s.uname) < 1 {
+	if len(s.Uname) < 1 {
 		return fmt.Errorf("missing session.uname")
 	}
 	return nil
 }
 
+type counter struct {
+	Name  string
+	Count int
+}
+
+func (c counter) Validate() error {
+	if len(c.Name) < 1 {
+		return fmt.Errorf("missing counter.name")
+	}
+	return nil
+}
+
 type person struct {
 	Name    string
 	Surname string
@@ -175,7 +259,7 @@ func twoFieldTest(db IDb) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to add table")
 	}
-	persons.DelAll()
+	persons.PurgeAll()
 
 	//both fields must be unique - put them in an index
 	uni, err := persons.Index("unique", []string{"Name", "Surname"})
@@ -200,9 +284,9 @@ func twoFieldTest(db IDb) error {
 
 	//retriev all by uid
 	for i, uid := range uidList {
-		pi := persons.GetItem(uid)
-		if pi == nil {
-			return fmt.Errorf("Failed to get on uid")
+		pi, err := persons.GetItem(uid)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get on uid")
 		}
 		pd := pi.Data().(person)
 		if pd.Name != list[i].Name || pd.Surname != list[i].Surname {
@@ -273,3 +357,1673 @@ func twoFieldTest(db IDb) error {
 
 	return nil
 } //twoFieldTest()
+
+func historyTest(db IDb) error {
+	users, err := db.Table("history", user{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	users.PurgeAll()
+	if db.GetTable("history") != users {
+		return fmt.Errorf("GetTable() did not return the table")
+	}
+
+	u1, err := users.AddItem(user{Name: "one"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add user one")
+	}
+	u1, err = u1.Upd(user{Name: "ONE"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to rename u1")
+	}
+	if err = u1.Del(); err != nil {
+		return errors.Wrapf(err, "Failed to del")
+	}
+
+	revs, err := users.History(u1.UID())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get history")
+	}
+	if len(revs) != 3 {
+		return fmt.Errorf("got %d revisions instead of 3", len(revs))
+	}
+	for i, rev := range revs {
+		if rev.UID() != u1.UID() || rev.Rev().Nr() != i+1 {
+			return fmt.Errorf("revision[%d] is %s.%d", i, rev.UID(), rev.Rev().Nr())
+		}
+		if rev.Rev().Deleted() != (i == 2) {
+			return fmt.Errorf("revision[%d].deleted=%v", i, rev.Rev().Deleted())
+		}
+	}
+	if revs[0].Data().(user).Name != "one" || revs[1].Data().(user).Name != "ONE" {
+		return fmt.Errorf("wrong history data %+v,%+v", revs[0].Data(), revs[1].Data())
+	}
+
+	if _, err := users.History("unknown"); err == nil {
+		return fmt.Errorf("got history of unknown item")
+	}
+	return nil
+} //historyTest()
+
+func watchTest(db IDb) error {
+	users, err := db.Table("watched", user{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	users.PurgeAll()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := users.Watch(ctx, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to watch")
+	}
+
+	u1, err := users.AddItem(user{Name: "one"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add user one")
+	}
+	u1, err = u1.Upd(user{Name: "ONE"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to rename u1")
+	}
+	if err = u1.Del(); err != nil {
+		return errors.Wrapf(err, "Failed to del")
+	}
+
+	expected := []Change{
+		{Type: Added, OldRev: 0, NewRev: 1},
+		{Type: Updated, OldRev: 1, NewRev: 2},
+		{Type: Deleted, OldRev: 2, NewRev: 3},
+	}
+	var first Change
+	for i, e := range expected {
+		c, err := nextChange(changes)
+		if err != nil {
+			return errors.Wrapf(err, "change[%d]", i)
+		}
+		if c.Type != e.Type || c.UID != u1.UID() || c.OldRev != e.OldRev || c.NewRev != e.NewRev || c.Item == nil {
+			return fmt.Errorf("change[%d]=%+v instead of %+v", i, c, e)
+		}
+		if i == 0 {
+			first = c
+		}
+	}
+	if first.Item.Data().(user).Name != "one" {
+		return fmt.Errorf("added data %+v", first.Item.Data())
+	}
+
+	//resume after the first change
+	resumed, err := users.Watch(ctx, first.Seq)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resume")
+	}
+	for i := 1; i < len(expected); i++ {
+		c, err := nextChange(resumed)
+		if err != nil {
+			return errors.Wrapf(err, "resumed change[%d]", i)
+		}
+		if c.Type != expected[i].Type || c.Seq != first.Seq+uint64(i) {
+			return fmt.Errorf("resumed change[%d]=%+v", i, c)
+		}
+	}
+
+	//channels are closed when ctx is done
+	cancel()
+	if _, err := nextChange(changes); err == nil {
+		return fmt.Errorf("got change after cancel")
+	}
+
+	//the backlog keeps the last changes in order after it is full, while slow watchers are dropped
+	feed := NewFeed(3)
+	slow, err := feed.Watch(context.Background(), 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to watch feed")
+	}
+	for i := 0; i < defaultWatchBuffer+5; i++ {
+		feed.Publish(Updated, u1, u1)
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != defaultWatchBuffer {
+		return fmt.Errorf("slow watcher got %d changes", n)
+	}
+	last := feed.Seq()
+	if _, err := feed.Watch(ctx, last-4); err == nil {
+		return fmt.Errorf("watched from change that is no longer in the backlog")
+	}
+	replayed, err := feed.Watch(context.Background(), last-3)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resume feed")
+	}
+	for _, seq := range []uint64{last - 2, last - 1, last} {
+		if c, err := nextChange(replayed); err != nil || c.Seq != seq {
+			return fmt.Errorf("replayed %+v,%v instead of %d", c, err, seq)
+		}
+	}
+	return nil
+} //watchTest()
+
+func nextChange(changes <-chan Change) (Change, error) {
+	select {
+	case c, ok := <-changes:
+		if !ok {
+			return Change{}, fmt.Errorf("closed")
+		}
+		return c, nil
+	case <-time.After(time.Second):
+		return Change{}, fmt.Errorf("timeout")
+	}
+}
+
+func hooksTest(db IDb) error {
+	persons, err := db.Table("hooked", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+
+	//stamp the surname on add, veto some updates and deletes
+	persons.Hooks().BeforeAdd(func(cur IItem, next IItem) (IData, error) {
+		if cur != nil {
+			return nil, fmt.Errorf("cur=%v when adding", cur)
+		}
+		p := next.Data().(person)
+		p.Surname = "stamped"
+		return p, nil
+	})
+	persons.Hooks().BeforeUpd(func(cur IItem, next IItem) (IData, error) {
+		if next.Data().(person).Name == "veto" {
+			return nil, fmt.Errorf("name may not be veto")
+		}
+		if cur == nil || cur.Rev().Nr()+1 != next.Rev().Nr() {
+			return nil, fmt.Errorf("cur=%v next=%v", cur, next)
+		}
+		return nil, nil
+	})
+	persons.Hooks().BeforeDel(func(cur IItem, next IItem) (IData, error) {
+		if cur.Data().(person).Name == "keep" {
+			return nil, fmt.Errorf("cannot delete keep")
+		}
+		return nil, nil
+	})
+	after := map[ChangeType]int{}
+	persons.Hooks().AfterAdd(func(old IItem, new IItem) { after[Added]++ })
+	persons.Hooks().AfterUpd(func(old IItem, new IItem) { after[Updated]++ })
+	persons.Hooks().AfterDel(func(old IItem, new IItem) {
+		if new.Rev().Deleted() && old.Rev().Nr()+1 == new.Rev().Nr() {
+			after[Deleted]++
+		}
+	})
+
+	p1, err := persons.AddItem(person{Name: "keep"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if got, err := persons.GetItem(p1.UID()); err != nil || p1.Data().(person).Surname != "stamped" || got.Data().(person).Surname != "stamped" {
+		return fmt.Errorf("not stamped on add: %+v", p1.Data())
+	}
+	if _, err := p1.Upd(person{Name: "veto"}); err == nil {
+		return fmt.Errorf("update was not vetoed")
+	}
+	if err := p1.Del(); err == nil {
+		return fmt.Errorf("delete was not vetoed")
+	}
+	if p1, err = p1.Upd(person{Name: "other"}); err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if err := p1.Del(); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+	if after[Added] != 1 || after[Updated] != 1 || after[Deleted] != 1 {
+		return fmt.Errorf("after hooks called %+v", after)
+	}
+	return nil
+} //hooksTest()
+
+func refsTest(db IDb) error {
+	members, err := db.Table("members", user{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	members.PurgeAll()
+	if _, err := members.Index("username", []string{"Name"}); err != nil {
+		return errors.Wrapf(err, "failed to add index")
+	}
+
+	//logins refer to members by name and prevent deletion
+	logins, err := db.Table("logins", session{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	logins.PurgeAll()
+	if err := logins.Ref("Uname", members, "username", RefReject); err != nil {
+		return errors.Wrapf(err, "failed to ref members")
+	}
+	if err := logins.Ref("Sid", members, "unknown", RefReject); err == nil {
+		return fmt.Errorf("ref to unknown index")
+	}
+
+	//guests are deleted with the member they refer to
+	guests, err := db.Table("guests", session{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	guests.PurgeAll()
+	if err := guests.Ref("Uname", members, "username", RefCascade); err != nil {
+		return errors.Wrapf(err, "failed to ref members")
+	}
+
+	//notes refer to members by uid which is cleared when the member is deleted
+	notes, err := db.Table("notes", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	notes.PurgeAll()
+	if err := notes.Ref("Surname", members, "", RefSetNull); err != nil {
+		return errors.Wrapf(err, "failed to ref members")
+	}
+	if len(members.RefsTo()) != 3 || len(notes.Refs()) != 1 {
+		return fmt.Errorf("%d refs to members, %d from notes", len(members.RefsTo()), len(notes.Refs()))
+	}
+
+	if _, err := logins.AddItem(session{Sid: "1", Uname: "bob"}); err == nil {
+		return fmt.Errorf("added login for unknown member")
+	}
+	bob, err := members.AddItem(user{Name: "bob"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add member")
+	}
+	login, err := logins.AddItem(session{Sid: "1", Uname: "bob"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add login")
+	}
+	if err := bob.Del(); err == nil {
+		return fmt.Errorf("deleted member with login")
+	}
+	//renaming removes the old key like a delete
+	if _, err := bob.Upd(user{Name: "bobby"}); err == nil {
+		return fmt.Errorf("renamed member with login")
+	}
+	if err := login.Del(); err != nil {
+		return errors.Wrapf(err, "failed to del login")
+	}
+	bob, err = bob.Upd(user{Name: "bobby"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to rename member")
+	}
+	if _, err := logins.AddItem(session{Sid: "2", Uname: "bob"}); err == nil {
+		return fmt.Errorf("added login for renamed member")
+	}
+
+	guest, err := guests.AddItem(session{Sid: "3", Uname: "bobby"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add guest")
+	}
+	note, err := notes.AddItem(person{Name: "note", Surname: bob.UID()})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add note")
+	}
+	if list, err := guests.ItemsWith("Uname", "bobby"); err != nil || len(list) != 1 || list[guest.UID()] == nil {
+		return fmt.Errorf("guests with name: %v,%v", list, err)
+	}
+
+	//visits refer to guests by uid and prevent deletion, also by a cascade from members
+	visits, err := db.Table("visits", session{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	visits.PurgeAll()
+	if err := visits.Ref("Sid", guests, "", RefReject); err != nil {
+		return errors.Wrapf(err, "failed to ref guests")
+	}
+	visit, err := visits.AddItem(session{Sid: guest.UID(), Uname: "visitor"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add visit")
+	}
+
+	//referring items are not changed when the delete fails
+	for _, del := range []func() error{
+		func() error { return members.DelItems([]IItem{DeletedItem(bob), DeletedItem(bob)}) },
+		func() error { return bob.Del() },
+	} {
+		if err := del(); err == nil {
+			return fmt.Errorf("deleted member twice in batch or with visit of guest")
+		}
+		if _, err := guests.GetItem(guest.UID()); err != nil {
+			return errors.Wrapf(err, "guest deleted by failed delete")
+		}
+		if note, err := notes.GetItem(note.UID()); err != nil || note.Data().(person).Surname != bob.UID() {
+			return fmt.Errorf("note cleared by failed delete: %+v,%v", note, err)
+		}
+	}
+	if err := visit.Del(); err != nil {
+		return errors.Wrapf(err, "failed to del visit")
+	}
+
+	if err := bob.Del(); err != nil {
+		return errors.Wrapf(err, "failed to del member")
+	}
+	if _, err := guests.GetItem(guest.UID()); !errors.Is(err, ErrDeleted) {
+		return fmt.Errorf("guest not deleted with member")
+	}
+	if note, err = notes.GetItem(note.UID()); err != nil || note.Data().(person).Surname != "" {
+		return fmt.Errorf("note not cleared: %+v,%v", note, err)
+	}
+	return nil
+} //refsTest()
+
+func retentionTest(db IDb) error {
+	users, err := db.Table("retained", user{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	users.PurgeAll()
+
+	//keep the last 2 revisions and only the tombstone of deleted items
+	users.SetRetention(Retention{KeepRevs: 2, TombstoneOnly: true})
+	if users.Retention().KeepRevs != 2 {
+		return fmt.Errorf("retention not set")
+	}
+
+	u1, err := users.AddItem(user{Name: "one"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add user one")
+	}
+	u2, err := users.AddItem(user{Name: "two"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add user two")
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if u1, err = u1.Upd(user{Name: name}); err != nil {
+			return errors.Wrapf(err, "Failed to update u1")
+		}
+		if u2, err = u2.Upd(user{Name: name}); err != nil {
+			return errors.Wrapf(err, "Failed to update u2")
+		}
+	}
+	if err := u2.Del(); err != nil {
+		return errors.Wrapf(err, "Failed to del u2")
+	}
+
+	//u1: 4 revisions of which 2 are pruned, u2: 5 of which only the tombstone is kept
+	n, err := users.Prune()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to prune")
+	}
+	if n != 6 {
+		return fmt.Errorf("pruned %d instead of 6", n)
+	}
+	revs, err := users.History(u1.UID())
+	if err != nil || len(revs) != 2 || revs[0].Rev().Nr() != 3 || revs[1].Rev().Nr() != 4 {
+		return fmt.Errorf("u1 history=%v,%v", revs, err)
+	}
+	revs, err = users.History(u2.UID())
+	if err != nil || len(revs) != 1 || !revs[0].Rev().Deleted() {
+		return fmt.Errorf("u2 history=%v,%v", revs, err)
+	}
+	if got, err := users.GetItem(u1.UID()); err != nil || got.Rev().Nr() != 4 {
+		return fmt.Errorf("cannot get u1 after prune")
+	}
+
+	//keeping nothing for long still keeps the latest revision
+	users.SetRetention(Retention{KeepFor: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	if n, err = users.Prune(); err != nil || n != 1 {
+		return fmt.Errorf("pruned %d,%v instead of 1", n, err)
+	}
+	if got, err := users.GetItem(u1.UID()); err != nil || got.Data().(user).Name != "c" {
+		return fmt.Errorf("cannot get u1 after prune")
+	}
+	return nil
+} //retentionTest()
+
+func contextTest(db IDb) error {
+	users, err := db.TableContext(context.Background(), "contexts", user{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	if err := users.PurgeAllContext(context.Background()); err != nil {
+		return errors.Wrapf(err, "failed to purge all")
+	}
+	u1, err := users.AddItemContext(context.Background(), user{Name: "one"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add user one")
+	}
+
+	//every operation fails with a cancelled context without changing anything
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.TableContext(ctx, "cancelled", user{}); err == nil {
+		return fmt.Errorf("added table with cancelled context")
+	}
+	if _, err := users.AddItemContext(ctx, user{Name: "two"}); err == nil {
+		return fmt.Errorf("added item with cancelled context")
+	}
+	if _, err := users.GetItemContext(ctx, u1.UID()); err == nil {
+		return fmt.Errorf("got item with cancelled context")
+	}
+	if _, err := u1.UpdContext(ctx, user{Name: "uno"}); err == nil {
+		return fmt.Errorf("updated item with cancelled context")
+	}
+	if err := u1.DelContext(ctx); err == nil {
+		return fmt.Errorf("deleted item with cancelled context")
+	}
+	if _, err := users.ItemsContext(ctx); err == nil {
+		return fmt.Errorf("got items with cancelled context")
+	}
+	if _, err := users.CountContext(ctx); err == nil {
+		return fmt.Errorf("counted items with cancelled context")
+	}
+	if _, err := users.HistoryContext(ctx, u1.UID()); err == nil {
+		return fmt.Errorf("got history with cancelled context")
+	}
+
+	got, err := users.GetItemContext(context.Background(), u1.UID())
+	if err != nil || got == nil || got.Rev().Nr() != 1 || got.Data().(user).Name != "one" {
+		return fmt.Errorf("u1 changed by cancelled operations: %v,%v", got, err)
+	}
+	return nil
+} //contextTest()
+
+func errorsTest(db IDb) error {
+	users, err := db.Table("errors", user{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	users.PurgeAll()
+	if _, err := users.Index("username", []string{"Name"}); err != nil {
+		return errors.Wrapf(err, "Failed to add username index")
+	}
+
+	if _, err := users.GetItem("unknown"); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("get unknown: %v instead of ErrNotFound", err)
+	}
+	if _, err := users.History("unknown"); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("history of unknown: %v instead of ErrNotFound", err)
+	}
+
+	var invalid *ErrInvalidData
+	if _, err := users.AddItem(user{}); !errors.As(err, &invalid) || invalid.Table != "errors" || invalid.Err == nil {
+		return fmt.Errorf("add invalid: %v instead of ErrInvalidData", err)
+	}
+	u1, err := users.AddItem(user{Name: "one"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add user one")
+	}
+	if _, err := u1.Upd(user{}); !errors.As(err, &invalid) {
+		return fmt.Errorf("upd invalid: %v instead of ErrInvalidData", err)
+	}
+
+	var duplicate *ErrDuplicateKey
+	if _, err := users.AddItem(user{Name: "one"}); !errors.As(err, &duplicate) || duplicate.Index != "username" {
+		return fmt.Errorf("add duplicate: %v instead of ErrDuplicateKey", err)
+	}
+	u2, err := users.AddItem(user{Name: "two"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add user two")
+	}
+	if _, err := u2.Upd(user{Name: "one"}); !errors.As(err, &duplicate) || duplicate.Index != "username" {
+		return fmt.Errorf("upd duplicate: %v instead of ErrDuplicateKey", err)
+	}
+
+	//concurrent adds with the same key store only one item
+	added := make(chan error)
+	for n := 0; n < 10; n++ {
+		go func() {
+			_, err := users.AddItem(user{Name: "three"})
+			added <- err
+		}()
+	}
+	nrAdded := 0
+	for n := 0; n < 10; n++ {
+		if err := <-added; err == nil {
+			nrAdded++
+		} else if !errors.As(err, &duplicate) {
+			return errors.Wrapf(err, "concurrent add")
+		}
+	}
+	if nrAdded != 1 {
+		return fmt.Errorf("concurrent adds stored %d items with the same key", nrAdded)
+	}
+
+	//write from a stale revision
+	var conflict *ErrRevisionConflict
+	if _, err := u1.Upd(user{Name: "uno"}); err != nil {
+		return errors.Wrapf(err, "Failed to update user one")
+	}
+	if _, err := u1.Upd(user{Name: "een"}); !errors.As(err, &conflict) || conflict.UID != u1.UID() || conflict.Expected != 1 || conflict.Actual != 2 {
+		return fmt.Errorf("upd stale: %v instead of ErrRevisionConflict(1,2)", err)
+	}
+	if err := u1.Del(); !errors.As(err, &conflict) || conflict.Expected != 1 || conflict.Actual != 2 {
+		return fmt.Errorf("del stale: %v instead of ErrRevisionConflict(1,2)", err)
+	}
+
+	if err := u2.Del(); err != nil {
+		return errors.Wrapf(err, "Failed to del user two")
+	}
+	if _, err := users.GetItem(u2.UID()); !errors.Is(err, ErrDeleted) {
+		return fmt.Errorf("get deleted: %v instead of ErrDeleted", err)
+	}
+	if _, err := u2.Upd(user{Name: "two"}); !errors.Is(err, ErrDeleted) {
+		return fmt.Errorf("upd deleted: %v instead of ErrDeleted", err)
+	}
+	return nil
+} //errorsTest()
+
+func typedTest(db IDb) error {
+	persons, err := TableOf[person](db, "typed")
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	if _, err := TableOf[user](db, "typed"); err == nil {
+		return fmt.Errorf("got typed table of the wrong type")
+	}
+	persons.Table().PurgeAll()
+	byName, err := persons.Index("name", []string{"Name"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add index")
+	}
+
+	p1, err := persons.Add(person{Name: "jan", Surname: "semmelink"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	got, err := persons.Get(p1.UID())
+	if err != nil || got.Data().Surname != "semmelink" {
+		return fmt.Errorf("got %+v,%v", got, err)
+	}
+	p1, err = persons.Upd(p1, person{Name: "jan", Surname: "other"})
+	if err != nil || p1.Rev().Nr() != 2 {
+		return fmt.Errorf("upd %+v,%v", p1, err)
+	}
+	if found, err := byName.FindOne(person{Name: "jan"}); err != nil || found.UID() != p1.UID() || found.Data().Surname != "other" {
+		return fmt.Errorf("found %+v,%v", found, err)
+	}
+	if _, err := byName.FindOne(person{Name: "unknown"}); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("found unknown: %v", err)
+	}
+	all, err := persons.Items()
+	if err != nil || len(all) != 1 || all[p1.UID()].Data().Surname != "other" {
+		return fmt.Errorf("items %+v,%v", all, err)
+	}
+	if err := persons.Del(p1); err != nil {
+		return errors.Wrapf(err, "failed to del")
+	}
+	revs, err := persons.History(p1.UID())
+	if err != nil || len(revs) != 3 || revs[0].Data().Surname != "semmelink" {
+		return fmt.Errorf("history %+v,%v", revs, err)
+	}
+	return nil
+} //typedTest()
+
+func batchTest(db IDb) error {
+	users, err := db.Table("batch", user{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	users.PurgeAll()
+	if _, err := users.Index("username", []string{"Name"}); err != nil {
+		return errors.Wrapf(err, "Failed to add username index")
+	}
+
+	//nothing is written when any entry fails
+	var batchErr *BatchError
+	_, err = users.AddItems([]IData{user{Name: "a"}, user{}, user{Name: "c"}, user{Name: "a"}})
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 1 || batchErr.Errs[1] == nil {
+		return fmt.Errorf("add invalid batch: %v", err)
+	}
+	_, err = users.AddItems([]IData{user{Name: "a"}, user{Name: "b"}, user{Name: "a"}})
+	var duplicate *ErrDuplicateKey
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 1 || !errors.As(batchErr.Errs[2], &duplicate) {
+		return fmt.Errorf("add duplicate batch: %v", err)
+	}
+	if n := users.Count(); n != 0 {
+		return fmt.Errorf("count=%d after failed batches", n)
+	}
+
+	list, err := users.AddItems([]IData{user{Name: "a"}, user{Name: "b"}, user{Name: "c"}})
+	if err != nil || len(list) != 3 || users.Count() != 3 {
+		return fmt.Errorf("add batch: %v,%v", list, err)
+	}
+	for n, name := range []string{"a", "b", "c"} {
+		if got, err := users.GetItem(list[n].UID()); err != nil || got.Data().(user).Name != name {
+			return fmt.Errorf("get batch[%d]: %v,%v", n, got, err)
+		}
+	}
+
+	//one stale entry fails the whole batch
+	if _, err := list[1].Upd(user{Name: "bb"}); err != nil {
+		return errors.Wrapf(err, "failed to update b")
+	}
+	var conflict *ErrRevisionConflict
+	_, err = users.UpdItems([]IItem{NextItem(list[0], user{Name: "aa"}), NextItem(list[1], user{Name: "bbb"})})
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 1 || !errors.As(batchErr.Errs[1], &conflict) {
+		return fmt.Errorf("upd stale batch: %v", err)
+	}
+	if got, _ := users.GetItem(list[0].UID()); got.Rev().Nr() != 1 {
+		return fmt.Errorf("a updated by failed batch")
+	}
+	if list[1], err = users.GetItem(list[1].UID()); err != nil {
+		return errors.Wrapf(err, "failed to get b")
+	}
+	updList, err := users.UpdItems([]IItem{NextItem(list[0], user{Name: "aa"}), NextItem(list[1], user{Name: "bbb"})})
+	if err != nil || len(updList) != 2 || updList[0].Rev().Nr() != 2 || updList[1].Rev().Nr() != 3 || updList[1].Data().(user).Name != "bbb" {
+		return fmt.Errorf("upd batch: %v,%v", updList, err)
+	}
+
+	if err := users.DelItems([]IItem{DeletedItem(updList[0]), DeletedItem(list[2])}); err != nil {
+		return errors.Wrapf(err, "failed to delete batch")
+	}
+	if all := users.Items(); len(all) != 1 || all[list[1].UID()] == nil {
+		return fmt.Errorf("items after delete batch: %v", all)
+	}
+	return nil
+} //batchTest()
+
+func modifyTest(db IDb) error {
+	users, err := db.Table("modified", user{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	users.PurgeAll()
+	users.SetRetry(Retry{Attempts: 3, Backoff: time.Millisecond})
+	u1, err := users.AddItem(user{Name: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add user")
+	}
+
+	//someone else writes the item during the first 2 attempts
+	interfere := func(n int) error {
+		cur, err := users.GetItem(u1.UID())
+		if err != nil {
+			return err
+		}
+		_, err = cur.Upd(user{Name: fmt.Sprintf("other%d", n)})
+		return err
+	}
+	attempts := 0
+	modified, err := users.Modify(u1.UID(), func(current IData) (IData, error) {
+		attempts++
+		if attempts < 3 {
+			if err := interfere(attempts); err != nil {
+				return nil, err
+			}
+		}
+		return user{Name: current.(user).Name + "+"}, nil
+	})
+	if err != nil || attempts != 3 || modified.Rev().Nr() != 4 || modified.Data().(user).Name != "other2+" {
+		return fmt.Errorf("modified %d times: %v,%v", attempts, modified, err)
+	}
+
+	//someone else writes the item during every attempt
+	var exhausted *ErrRetriesExhausted
+	var conflict *ErrRevisionConflict
+	attempts = 0
+	_, err = users.Modify(u1.UID(), func(current IData) (IData, error) {
+		attempts++
+		if err := interfere(attempts); err != nil {
+			return nil, err
+		}
+		return current, nil
+	})
+	if !errors.As(err, &exhausted) || exhausted.Attempts != 3 || attempts != 3 || !errors.As(err, &conflict) {
+		return fmt.Errorf("modify with conflicts: %d attempts: %v", attempts, err)
+	}
+
+	//errors other than conflicts are not retried
+	attempts = 0
+	if _, err := users.Modify(u1.UID(), func(current IData) (IData, error) {
+		attempts++
+		return user{}, nil
+	}); err == nil || attempts != 1 {
+		return fmt.Errorf("modify invalid: %d attempts: %v", attempts, err)
+	}
+	if _, err := users.Modify("unknown", func(current IData) (IData, error) { return current, nil }); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("modify unknown: %v", err)
+	}
+	return nil
+} //modifyTest()
+
+func patchTest(db IDb) error {
+	counters, err := db.Table("patched", counter{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	counters.PurgeAll()
+	c1, err := counters.AddItem(counter{Name: "a", Count: 1})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add counter")
+	}
+
+	//values are converted to the field types, other fields are kept
+	c1, err = counters.Patch(c1, map[string]interface{}{"Count": float64(5)})
+	if err != nil || c1.Rev().Nr() != 2 || c1.Data().(counter) != (counter{Name: "a", Count: 5}) {
+		return fmt.Errorf("patch count: %v,%v", c1, err)
+	}
+	c1, err = counters.Patch(c1, map[string]interface{}{"Name": "b", "Count": "6"})
+	if err != nil || c1.Data().(counter) != (counter{Name: "b", Count: 6}) {
+		return fmt.Errorf("patch name and count: %v,%v", c1, err)
+	}
+
+	var invalid *ErrInvalidData
+	for _, fields := range []map[string]interface{}{
+		{"Unknown": 1},
+		{"Count": 1.5},
+		{"Count": "many"},
+		{"Name": ""},
+	} {
+		if _, err := counters.Patch(c1, fields); !errors.As(err, &invalid) {
+			return fmt.Errorf("patch %v: %v instead of ErrInvalidData", fields, err)
+		}
+	}
+	if got, err := counters.GetItem(c1.UID()); err != nil || got.Rev().Nr() != 3 {
+		return fmt.Errorf("changed by invalid patches: %v,%v", got, err)
+	}
+
+	//negative values do not wrap around in unsigned fields, or the other way round
+	for _, c := range []struct {
+		value interface{}
+		to    interface{}
+	}{{-1, uint(0)}, {float64(-1), uint8(0)}, {int64(-5), uint64(0)}, {^uint64(0), int64(0)}} {
+		if converted, err := ConvertValue(c.value, reflect.TypeOf(c.to)); err == nil {
+			return fmt.Errorf("converted %T(%v) to %T(%v)", c.value, c.value, converted, converted)
+		}
+	}
+	if converted, err := ConvertValue(float64(7), reflect.TypeOf(uint(0))); err != nil || converted != uint(7) {
+		return fmt.Errorf("converted 7 to %v,%v", converted, err)
+	}
+	return nil
+} //patchTest()
+
+type address struct {
+	City string
+	Zip  int
+}
+
+type profile struct {
+	Name    string
+	Home    address
+	Work    *address
+	Tags    []string
+	Attrs   map[string]int
+	Updated time.Time
+	secret  string
+}
+
+func (p profile) Validate() error {
+	return nil
+}
+
+func diffTest(db IDb) error {
+	persons, err := db.Table("diffed", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	p1, err := persons.AddItem(person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	p2, err := p1.Upd(person{Name: "jan", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	revs, err := persons.History(p1.UID())
+	if err != nil || len(revs) != 2 {
+		return fmt.Errorf("history: %v,%v", revs, err)
+	}
+	diffs, err := Diff(revs[0], revs[1])
+	if err != nil || len(diffs) != 1 || diffs[0] != (FieldDiff{Path: "Surname", Old: "a", New: "b"}) {
+		return fmt.Errorf("diff revs: %v,%v", diffs, err)
+	}
+	if diffs, err = Diff(p2, p2); err != nil || len(diffs) != 0 {
+		return fmt.Errorf("diff same: %v,%v", diffs, err)
+	}
+	if diffs, err = Diff(nil, p1); err != nil || len(diffs) != 2 || diffs[0].Path != "Name" || diffs[0].Old != "" {
+		return fmt.Errorf("diff first: %v,%v", diffs, err)
+	}
+
+	//nested fields
+	now := time.Now()
+	a := profile{Name: "a", Home: address{City: "x", Zip: 1}, Tags: []string{"t1", "t2"}, Attrs: map[string]int{"k1": 1, "k2": 2}, Updated: now, secret: "s1"}
+	b := profile{Name: "a", Home: address{City: "y", Zip: 1}, Work: &address{City: "z"}, Tags: []string{"t1", "t3", "t4"}, Attrs: map[string]int{"k2": 3, "k3": 4}, Updated: now.UTC(), secret: "s2"}
+	diffs, err = DiffData(a, b)
+	if err != nil {
+		return errors.Wrapf(err, "failed to diff")
+	}
+	expected := []string{
+		"Home.City: x -> y",
+		"Work: <nil> -> &{z 0}",
+		"Tags[1]: t2 -> t3",
+		"Tags[2]: <nil> -> t4",
+		"Attrs[k1]: 1 -> <nil>",
+		"Attrs[k2]: 2 -> 3",
+		"Attrs[k3]: <nil> -> 4",
+	}
+	if len(diffs) != len(expected) {
+		return fmt.Errorf("diff nested: %v", diffs)
+	}
+	for n, d := range diffs {
+		if d.String() != expected[n] {
+			return fmt.Errorf("diff[%d]=%s instead of %s", n, d, expected[n])
+		}
+	}
+	if _, err := DiffData(a, person{}); err == nil {
+		return fmt.Errorf("diffed different types")
+	}
+	return nil
+} //diffTest()
+
+func revInfoTest(db IDb) error {
+	persons, err := db.Table("annotated", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	hookActors := []string{}
+	persons.Hooks().BeforeUpd(func(cur IItem, next IItem) (IData, error) {
+		hookActors = append(hookActors, next.Rev().Actor())
+		return next.Data(), nil
+	})
+
+	ctx := WithAnnotation(WithReason(WithActor(context.Background(), "jan"), "signup"), "ticket", "T1")
+	p1, err := persons.AddItemContext(ctx, person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if p1.Rev().Actor() != "jan" || p1.Rev().Reason() != "signup" || p1.Rev().Annotations()["ticket"] != "T1" {
+		return fmt.Errorf("added rev %+v", p1.Rev())
+	}
+	p2, err := p1.UpdContext(WithActor(context.Background(), "admin"), person{Name: "jan", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if len(hookActors) != 1 || hookActors[0] != "admin" {
+		return fmt.Errorf("hook saw actors %v", hookActors)
+	}
+	p3, err := p2.Upd(person{Name: "jan", Surname: "c"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if err := p3.DelContext(WithReason(context.Background(), "closed")); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+
+	revs, err := persons.History(p1.UID())
+	if err != nil || len(revs) != 4 {
+		return fmt.Errorf("history: %v,%v", revs, err)
+	}
+	expected := []RevInfo{
+		{Actor: "jan", Reason: "signup", Annotations: map[string]string{"ticket": "T1"}},
+		{Actor: "admin"},
+		{},
+		{Reason: "closed"},
+	}
+	for n, rev := range revs {
+		info := RevInfoOf(rev.Rev())
+		if info.Actor != expected[n].Actor || info.Reason != expected[n].Reason || !reflect.DeepEqual(info.Annotations, expected[n].Annotations) {
+			return fmt.Errorf("rev[%d] info %+v instead of %+v", n, info, expected[n])
+		}
+	}
+	if !revs[3].Rev().Deleted() {
+		return fmt.Errorf("last rev not deleted")
+	}
+	return nil
+} //revInfoTest()
+
+func createdTest(db IDb) error {
+	persons, err := db.Table("created", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	p1, err := persons.AddItemContext(WithActor(context.Background(), "jan"), person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if p1.Created() == nil || p1.Created().Nr() != 1 || !p1.Created().Timestamp().Equal(p1.Rev().Timestamp()) || p1.Created().Actor() != "jan" || p1.Revisions() != 1 {
+		return fmt.Errorf("added created=%+v revisions=%d", p1.Created(), p1.Revisions())
+	}
+	time.Sleep(time.Millisecond * 10)
+	p2, err := p1.UpdContext(WithActor(context.Background(), "admin"), person{Name: "jan", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	p3, err := p2.Upd(person{Name: "jan", Surname: "c"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if p3.Revisions() != 3 || p3.Created().Actor() != "jan" || !p3.Created().Timestamp().Truncate(time.Millisecond).Equal(p1.Rev().Timestamp().Truncate(time.Millisecond)) {
+		return fmt.Errorf("updated created=%+v revisions=%d", p3.Created(), p3.Revisions())
+	}
+
+	//reads have it too, with timestamps in ms like the sql backend stores them
+	got, err := persons.GetItem(p1.UID())
+	if err != nil || got.Created().Actor() != "jan" || !got.Created().Timestamp().Truncate(time.Millisecond).Equal(p1.Created().Timestamp().Truncate(time.Millisecond)) || got.Revisions() != 3 {
+		return fmt.Errorf("get: %v,%v", got, err)
+	}
+	revs, err := persons.History(p1.UID())
+	if err != nil || len(revs) != 3 {
+		return fmt.Errorf("history: %v,%v", revs, err)
+	}
+	for n, rev := range revs {
+		if rev.Created().Actor() != "jan" || rev.Revisions() != n+1 {
+			return fmt.Errorf("rev[%d] created=%+v revisions=%d", n, rev.Created(), rev.Revisions())
+		}
+	}
+	if err := p3.Del(); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+	if revs, err = persons.History(p1.UID()); err != nil || len(revs) != 4 || revs[3].Created().Actor() != "jan" {
+		return fmt.Errorf("deleted history: %v,%v", revs, err)
+	}
+	return nil
+} //createdTest()
+
+func nidTest(db IDb) error {
+	persons, err := db.Table("numbered", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	p1, err := persons.AddItem(person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	p2, err := persons.AddItem(person{Name: "piet", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if p1.NID() < 1 || p2.NID() == p1.NID() {
+		return fmt.Errorf("nids %d,%d", p1.NID(), p2.NID())
+	}
+	upd, err := p1.Upd(person{Name: "jan", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if upd.NID() != p1.NID() {
+		return fmt.Errorf("updated nid=%d instead of %d", upd.NID(), p1.NID())
+	}
+	got, err := persons.GetItemByNID(p1.NID())
+	if err != nil || got.UID() != p1.UID() || got.Rev().Nr() != 2 || got.NID() != p1.NID() {
+		return fmt.Errorf("get by nid: %v,%v", got, err)
+	}
+	revs, err := persons.History(p1.UID())
+	if err != nil || len(revs) != 2 || revs[0].NID() != p1.NID() || revs[1].NID() != p1.NID() {
+		return fmt.Errorf("history: %v,%v", revs, err)
+	}
+	if _, err := persons.GetItemByNID(p2.NID() + 100); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("get unknown nid: %v", err)
+	}
+	if err := upd.Del(); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+	if _, err := persons.GetItemByNID(p1.NID()); !errors.Is(err, ErrDeleted) {
+		return fmt.Errorf("get deleted nid: %v", err)
+	}
+	return nil
+} //nidTest()
+
+//currentTest checks that reads only see the latest revision of items that are not deleted
+func currentTest(db IDb) error {
+	persons, err := db.Table("current", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	bySurname, err := persons.Index("bySurname", []string{"Surname"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add index")
+	}
+	p1, err := persons.AddItem(person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	p2, err := persons.AddItem(person{Name: "piet", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if _, err := persons.AddItem(person{Name: "koos", Surname: "c"}); err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	//several revisions of p1 must still count as one item
+	for _, surname := range []string{"x", "y", "z"} {
+		if p1, err = p1.Upd(person{Name: "jan", Surname: surname}); err != nil {
+			return errors.Wrapf(err, "failed to update")
+		}
+	}
+	if err := p2.Del(); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+	if n := persons.Count(); n != 2 {
+		return fmt.Errorf("count=%d instead of 2", n)
+	}
+	list := persons.Items()
+	if len(list) != 2 || list[p1.UID()] == nil || list[p1.UID()].Rev().Nr() != 4 || list[p2.UID()] != nil {
+		return fmt.Errorf("items: %v", list)
+	}
+
+	//the index only finds keys of current revisions
+	for surname, expected := range map[string]string{"a": "", "b": "", "c": "koos", "z": "jan"} {
+		found, err := bySurname.FindOne(map[string]interface{}{"Surname": surname})
+		if err != nil {
+			return errors.Wrapf(err, "failed to find %s", surname)
+		}
+		if (found == nil && expected != "") || (found != nil && found.Data().(person).Name != expected) {
+			return fmt.Errorf("found %s: %v instead of %s", surname, found, expected)
+		}
+	}
+	//old keys can be used again
+	if _, err := persons.AddItem(person{Name: "sarel", Surname: "a"}); err != nil {
+		return errors.Wrapf(err, "failed to add with old key")
+	}
+	if _, err := persons.AddItem(person{Name: "sarel", Surname: "z"}); !errors.As(err, new(*ErrDuplicateKey)) {
+		return fmt.Errorf("added with current key: %v", err)
+	}
+	return nil
+} //currentTest()
+
+func restoreTest(db IDb) error {
+	persons, err := db.Table("restored", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	p1, err := persons.AddItem(person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	p2, err := p1.Upd(person{Name: "jan", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+
+	//revert to rev 1 as rev 3
+	p3, err := persons.Revert(p1.UID(), 1)
+	if err != nil {
+		return errors.Wrapf(err, "failed to revert")
+	}
+	if p3.Rev().Nr() != 3 || p3.NID() != p1.NID() || p3.Data().(person).Surname != "a" {
+		return fmt.Errorf("reverted: %+v", p3)
+	}
+	if _, err := persons.Revert(p1.UID(), 9); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("reverted to unknown rev: %v", err)
+	}
+	if _, err := p2.Upd(person{Name: "jan", Surname: "c"}); !errors.As(err, new(*ErrRevisionConflict)) {
+		return fmt.Errorf("updated old rev after revert: %v", err)
+	}
+	if _, err := persons.Restore(p1.UID()); err == nil {
+		return fmt.Errorf("restored item that is not deleted")
+	}
+
+	//delete as rev 4 and restore as rev 5
+	if err := p3.Del(); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+	if _, err := persons.Revert(p1.UID(), 2); !errors.Is(err, ErrDeleted) {
+		return fmt.Errorf("reverted deleted item: %v", err)
+	}
+	if _, err := persons.Restore("unknown"); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("restored unknown item: %v", err)
+	}
+	p5, err := persons.Restore(p1.UID())
+	if err != nil {
+		return errors.Wrapf(err, "failed to restore")
+	}
+	if p5.Rev().Nr() != 5 || p5.Rev().Deleted() || p5.NID() != p1.NID() || p5.Data().(person).Surname != "a" {
+		return fmt.Errorf("restored: %+v", p5)
+	}
+	got, err := persons.GetItem(p1.UID())
+	if err != nil || got.Rev().Nr() != 5 {
+		return fmt.Errorf("get restored: %v,%v", got, err)
+	}
+	if persons.Count() != 1 {
+		return fmt.Errorf("count=%d after restore", persons.Count())
+	}
+	if _, err := persons.Restore(p1.UID()); err == nil {
+		return fmt.Errorf("restored twice")
+	}
+	revs, err := persons.History(p1.UID())
+	if err != nil || len(revs) != 5 || !revs[3].Rev().Deleted() {
+		return fmt.Errorf("history: %v,%v", revs, err)
+	}
+	if _, err := p5.Upd(person{Name: "jan", Surname: "d"}); err != nil {
+		return errors.Wrapf(err, "failed to update restored item")
+	}
+	return nil
+} //restoreTest()
+
+func purgeTest(db IDb) error {
+	persons, err := db.Table("purged", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	purges, err := persons.Purges()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get purges")
+	}
+	nrPurges := len(purges)
+	list, err := persons.AddItems([]IData{person{Name: "jan", Surname: "a"}, person{Name: "piet", Surname: "b"}, person{Name: "koos", Surname: "c"}})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if _, err := list[0].Upd(person{Name: "jan", Surname: "x"}); err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if err := list[2].Del(); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+
+	//delete all writes a deleted revision for each item that is not yet deleted
+	if err := persons.DelAll(); err != nil {
+		return errors.Wrapf(err, "failed to delete all")
+	}
+	if n := persons.Count(); n != 0 {
+		return fmt.Errorf("count=%d after delete all", n)
+	}
+	for n, expected := range []int{3, 2, 2} {
+		revs, err := persons.History(list[n].UID())
+		if err != nil || len(revs) != expected || !revs[len(revs)-1].Rev().Deleted() {
+			return fmt.Errorf("history[%d]: %v,%v", n, revs, err)
+		}
+	}
+	if _, err := persons.Restore(list[0].UID()); err != nil {
+		return errors.Wrapf(err, "failed to restore after delete all")
+	}
+
+	//purge removes all revisions and records it
+	ctx := WithReason(WithActor(context.Background(), "dpo"), "erasure request")
+	if err := persons.PurgeContext(ctx, list[0].UID()); err != nil {
+		return errors.Wrapf(err, "failed to purge")
+	}
+	if _, err := persons.History(list[0].UID()); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("history of purged item: %v", err)
+	}
+	if _, err := persons.GetItem(list[0].UID()); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("get purged item: %v", err)
+	}
+	if err := persons.Purge(list[0].UID()); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("purged twice: %v", err)
+	}
+	if n := persons.Count(); n != 0 {
+		return fmt.Errorf("count=%d after purge", n)
+	}
+	purges, err = persons.Purges()
+	if err != nil || len(purges) != nrPurges+1 {
+		return fmt.Errorf("purges: %v,%v", purges, err)
+	}
+	if p := purges[nrPurges]; p.UID != list[0].UID() || p.NID != list[0].NID() || p.Revisions != 4 || p.Actor != "dpo" || p.Reason != "erasure request" {
+		return fmt.Errorf("purged %+v", p)
+	}
+
+	if err := persons.PurgeAll(); err != nil {
+		return errors.Wrapf(err, "failed to purge all")
+	}
+	for _, item := range list {
+		if _, err := persons.History(item.UID()); !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("history of purged item: %v", err)
+		}
+	}
+	if purges, err = persons.Purges(); err != nil || len(purges) != nrPurges+3 {
+		return fmt.Errorf("purges: %v,%v", purges, err)
+	}
+	return nil
+} //purgeTest()
+
+func validTimeTest(db IDb) error {
+	prices, err := db.Table("prices", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	prices.PurgeAll()
+	month := func(m time.Month) time.Time { return time.Date(2020, m, 1, 0, 0, 0, 0, time.UTC) }
+
+	//price 10 from january, then recorded later: price 12 from april
+	p, err := prices.AddItemContext(WithValidTime(context.Background(), month(time.January), time.Time{}), person{Name: "tea", Surname: "10"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	time.Sleep(time.Millisecond)
+	known1 := db.Now()
+	time.Sleep(time.Millisecond)
+	p, err = p.UpdContext(WithValidTime(context.Background(), month(time.April), time.Time{}), person{Name: "tea", Surname: "12"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if !p.Rev().ValidFrom().Equal(month(time.April)) || !p.Rev().ValidTo().IsZero() || p.Rev().ValidAt(month(time.March)) || !p.Rev().ValidAt(month(time.April)) {
+		return fmt.Errorf("valid %v..%v", p.Rev().ValidFrom(), p.Rev().ValidTo())
+	}
+	time.Sleep(time.Millisecond)
+	known2 := db.Now()
+
+	for _, c := range []struct {
+		knownAt time.Time
+		validAt time.Time
+		price   string
+	}{
+		{knownAt: known2, validAt: month(time.February), price: "10"},
+		{knownAt: known2, validAt: month(time.May), price: "12"},
+		{knownAt: known1, validAt: month(time.May), price: "10"},
+	} {
+		item, err := prices.GetItemAsOf(p.UID(), c.knownAt, c.validAt)
+		if err != nil || item.Data().(person).Surname != c.price {
+			return fmt.Errorf("as of %v valid at %v: %v,%v instead of %s", c.knownAt, c.validAt, item, err, c.price)
+		}
+		list, err := prices.ItemsAsOf(c.knownAt, c.validAt)
+		if err != nil || len(list) != 1 || list[p.UID()].Data().(person).Surname != c.price {
+			return fmt.Errorf("items as of %v valid at %v: %v,%v instead of %s", c.knownAt, c.validAt, list, err, c.price)
+		}
+	}
+	if _, err := prices.GetItemAsOf(p.UID(), known2, month(time.January).Add(-time.Second)); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("before valid time: %v", err)
+	}
+
+	//deleted from june
+	if err := p.DelContext(WithValidTime(context.Background(), month(time.June), time.Time{})); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+	if _, err := prices.GetItemAsOf(p.UID(), db.Now(), month(time.July)); !errors.Is(err, ErrDeleted) {
+		return fmt.Errorf("after deleted: %v", err)
+	}
+	if item, err := prices.GetItemAsOf(p.UID(), db.Now(), month(time.May)); err != nil || item.Data().(person).Surname != "12" {
+		return fmt.Errorf("before deleted: %v,%v", item, err)
+	}
+	if list, err := prices.ItemsAsOf(db.Now(), month(time.July)); err != nil || len(list) != 0 {
+		return fmt.Errorf("items after deleted: %v,%v", list, err)
+	}
+	return nil
+} //validTimeTest()
+
+func scheduleTest(db IDb, clock *testClock) error {
+	prices, err := TableOf[person](db, "scheduled")
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	prices.Table().PurgeAll()
+	p, err := prices.Add(person{Name: "tea", Surname: "10"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if _, err := prices.Schedule(p, person{Name: "tea", Surname: "11"}, db.Now().Add(-time.Second)); err == nil {
+		return fmt.Errorf("scheduled in the past")
+	}
+
+	//the scheduled revision is written when its time has come
+	at := db.Now().Add(time.Millisecond * 20)
+	ctx := WithActor(context.Background(), "pricing")
+	s, err := prices.ScheduleContext(ctx, p, person{Name: "tea", Surname: "12"}, at)
+	if err != nil {
+		return errors.Wrapf(err, "failed to schedule")
+	}
+	if s.UID != p.UID() || s.RevNr != 2 {
+		return fmt.Errorf("scheduled %+v", s)
+	}
+	if got, err := prices.Get(p.UID()); err != nil || got.Data().Surname != "10" {
+		return fmt.Errorf("before scheduled time: %v,%v", got, err)
+	}
+	if list, err := prices.Table().Scheduled(); err != nil || len(list) != 1 || list[0].ID != s.ID || list[0].Info.Actor != "pricing" {
+		return fmt.Errorf("scheduled: %+v,%v", list, err)
+	}
+	clock.Add(time.Millisecond * 30)
+	got, err := prices.Get(p.UID())
+	if err != nil || got.Data().Surname != "12" || got.Rev().Nr() != 2 || got.Rev().Actor() != "pricing" ||
+		!got.Rev().Timestamp().Truncate(time.Millisecond).Equal(at.Truncate(time.Millisecond)) {
+		return fmt.Errorf("after scheduled time: %v,%v", got, err)
+	}
+	if list, err := prices.Table().Scheduled(); err != nil || len(list) != 0 {
+		return fmt.Errorf("scheduled after written: %+v,%v", list, err)
+	}
+
+	//an update before the scheduled time makes it fail with a conflict
+	s, err = prices.Schedule(got, person{Name: "tea", Surname: "13"}, db.Now().Add(time.Millisecond*20))
+	if err != nil {
+		return errors.Wrapf(err, "failed to schedule")
+	}
+	if _, err := prices.Schedule(p, person{Name: "tea", Surname: "14"}, db.Now().Add(time.Millisecond*20)); !errors.As(err, new(*ErrRevisionConflict)) {
+		return fmt.Errorf("scheduled old revision: %v", err)
+	}
+	if got, err = prices.Upd(got, person{Name: "tea", Surname: "15"}); err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	clock.Add(time.Millisecond * 30)
+	if got, err = prices.Get(p.UID()); err != nil || got.Data().Surname != "15" || got.Rev().Nr() != 3 {
+		return fmt.Errorf("after conflicting scheduled time: %v,%v", got, err)
+	}
+	list, err := prices.Table().Scheduled()
+	if err != nil || len(list) != 1 || list[0].ID != s.ID || list[0].Failure == "" {
+		return fmt.Errorf("failed scheduled: %+v,%v", list, err)
+	}
+	if err := prices.Table().CancelScheduled(s.ID); err != nil {
+		return errors.Wrapf(err, "failed to cancel")
+	}
+	if err := prices.Table().CancelScheduled(s.ID); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("cancelled twice: %v", err)
+	}
+
+	//a cancelled revision is not written
+	if s, err = prices.Schedule(got, person{Name: "tea", Surname: "16"}, db.Now().Add(time.Millisecond*20)); err != nil {
+		return errors.Wrapf(err, "failed to schedule")
+	}
+	if err := prices.Table().CancelScheduled(s.ID); err != nil {
+		return errors.Wrapf(err, "failed to cancel")
+	}
+	clock.Add(time.Millisecond * 30)
+	if got, err = prices.Get(p.UID()); err != nil || got.Data().Surname != "15" {
+		return fmt.Errorf("after cancelled scheduled time: %v,%v", got, err)
+	}
+	return nil
+} //scheduleTest()
+
+func draftTest(db IDb) error {
+	persons, err := TableOf[person](db, "drafted")
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.Table().PurgeAll()
+	p, err := persons.Add(person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	maker := WithReason(WithActor(context.Background(), "maker"), "typo")
+	checker := WithActor(context.Background(), "checker")
+
+	//the draft is written when approved by another actor
+	if _, err := persons.Table().Propose(nil); err == nil {
+		return fmt.Errorf("proposed nil")
+	}
+	if _, err := persons.Propose(p, person{Name: "jan", Surname: "b"}); !errors.Is(err, ErrNoActor) {
+		return fmt.Errorf("proposed without actor: %v", err)
+	}
+	d, err := persons.ProposeContext(maker, p, person{Name: "jan", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to propose")
+	}
+	if d.UID != p.UID() || d.RevNr != 2 || d.Info.Actor != "maker" {
+		return fmt.Errorf("draft %+v", d)
+	}
+	if got, err := persons.Get(p.UID()); err != nil || got.Data().Surname != "a" {
+		return fmt.Errorf("before approved: %v,%v", got, err)
+	}
+	if list, err := persons.Table().Drafts(); err != nil || len(list) != 1 || list[0].ID != d.ID || list[0].Data.(person).Surname != "b" {
+		return fmt.Errorf("drafts: %+v,%v", list, err)
+	}
+	if _, err := persons.ApproveContext(maker, d.ID); !errors.Is(err, ErrSelfApproval) {
+		return fmt.Errorf("approved by maker: %v", err)
+	}
+	if _, err := persons.Approve(d.ID); !errors.Is(err, ErrNoActor) {
+		return fmt.Errorf("approved without actor: %v", err)
+	}
+	p, err = persons.ApproveContext(checker, d.ID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to approve")
+	}
+	if p.Rev().Nr() != 2 || p.Data().Surname != "b" || p.Rev().Actor() != "maker" || p.Rev().Reason() != "typo" || p.Rev().Annotations()[ApprovedByAnnotation] != "checker" {
+		return fmt.Errorf("approved %v: %+v", p.Rev(), p.Data())
+	}
+	if list, err := persons.Table().Drafts(); err != nil || len(list) != 0 {
+		return fmt.Errorf("drafts after approved: %+v,%v", list, err)
+	}
+	if _, err := persons.ApproveContext(checker, d.ID); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("approved twice: %v", err)
+	}
+
+	//approval fails when the item was written after the draft was proposed
+	if d, err = persons.ProposeContext(maker, p, person{Name: "jan", Surname: "c"}); err != nil {
+		return errors.Wrapf(err, "failed to propose")
+	}
+	if _, err := persons.Upd(p, person{Name: "jan", Surname: "d"}); err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if _, err := persons.ApproveContext(checker, d.ID); !errors.As(err, new(*ErrRevisionConflict)) {
+		return fmt.Errorf("approved after update: %v", err)
+	}
+	if err := persons.Table().Reject(d.ID); err != nil {
+		return errors.Wrapf(err, "failed to reject")
+	}
+	if err := persons.Table().Reject(d.ID); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("rejected twice: %v", err)
+	}
+	if got, err := persons.Get(p.UID()); err != nil || got.Data().Surname != "d" || got.Rev().Nr() != 3 {
+		return fmt.Errorf("after rejected: %v,%v", got, err)
+	}
+	return nil
+} //draftTest()
+
+func ttlTest(db IDb, clock *testClock) error {
+	persons, err := db.Table("expiring", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	byName, err := persons.Index("name", []string{"Name"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add index")
+	}
+	persons.SetTTL(TTL{After: time.Millisecond * 20})
+	defer persons.SetTTL(TTL{})
+	p1, err := persons.AddItem(person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	//the expiry of the revision takes precedence over the ttl
+	p2, err := persons.AddItemContext(WithExpiry(context.Background(), db.Now().Add(time.Hour)), person{Name: "piet", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if got, err := persons.GetItem(p1.UID()); err != nil || got.UID() != p1.UID() {
+		return fmt.Errorf("before expired: %v,%v", got, err)
+	}
+	clock.Add(time.Millisecond * 30)
+
+	//expired items are treated as deleted before they are swept
+	if _, err := persons.GetItem(p1.UID()); !errors.Is(err, ErrDeleted) {
+		return fmt.Errorf("get expired item: %v", err)
+	}
+	if got, err := persons.GetItem(p2.UID()); err != nil || got.UID() != p2.UID() {
+		return fmt.Errorf("get item with later expiry: %v,%v", got, err)
+	}
+	if n, list := persons.Count(), persons.Items(); n != 1 || len(list) != 1 || list[p2.UID()] == nil {
+		return fmt.Errorf("count=%d items=%v after expired", n, list)
+	}
+	if found, err := byName.FindOne(map[string]interface{}{"Name": "jan"}); err != nil || found != nil {
+		return fmt.Errorf("found expired item: %v,%v", found, err)
+	}
+	p3, err := persons.AddItem(person{Name: "jan", Surname: "c"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add with key of expired item")
+	}
+
+	//sweep writes the deleted revisions
+	if n, err := persons.Sweep(); err != nil || n != 1 {
+		return fmt.Errorf("swept %d,%v", n, err)
+	}
+	revs, err := persons.History(p1.UID())
+	if err != nil || len(revs) != 2 || !revs[1].Rev().Deleted() || revs[1].Rev().Reason() != ExpiredReason {
+		return fmt.Errorf("history of swept item: %v,%v", revs, err)
+	}
+	if found, err := byName.FindOne(map[string]interface{}{"Name": "jan"}); err != nil || found == nil || found.UID() != p3.UID() {
+		return fmt.Errorf("found %v,%v after sweep", found, err)
+	}
+
+	//or purges them
+	persons.SetTTL(TTL{After: time.Millisecond * 20, Purge: true})
+	clock.Add(time.Millisecond * 30)
+	if n, err := persons.Sweep(); err != nil || n != 1 {
+		return fmt.Errorf("swept %d,%v", n, err)
+	}
+	if _, err := persons.History(p3.UID()); !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("history of purged item: %v", err)
+	}
+	if n, err := persons.Sweep(); err != nil || n != 0 {
+		return fmt.Errorf("swept again %d,%v", n, err)
+	}
+	return nil
+} //ttlTest()
+
+func upsertTest(db IDb) error {
+	persons, err := TableOf[person](db, "upserted")
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.Table().PurgeAll()
+	if _, err := persons.Index("name", []string{"Name"}); err != nil {
+		return errors.Wrapf(err, "failed to add index")
+	}
+	if _, _, err := persons.Upsert("unknown", person{Name: "jan", Surname: "a"}); err == nil {
+		return fmt.Errorf("upsert with unknown index")
+	}
+
+	//upsert adds the item when the key is not used, else updates it
+	p, created, err := persons.Upsert("name", person{Name: "jan", Surname: "a"})
+	if err != nil || !created || p.Rev().Nr() != 1 {
+		return fmt.Errorf("upsert new: %v,%v,%v", p, created, err)
+	}
+	upd, created, err := persons.Upsert("name", person{Name: "jan", Surname: "b"})
+	if err != nil || created || upd.UID() != p.UID() || upd.Rev().Nr() != 2 || upd.Data().Surname != "b" {
+		return fmt.Errorf("upsert existing: %v,%v,%v", upd, created, err)
+	}
+
+	//get or create returns the existing item without writing it
+	got, created, err := persons.GetOrCreate("name", person{Name: "jan", Surname: "c"})
+	if err != nil || created || got.UID() != p.UID() || got.Rev().Nr() != 2 || got.Data().Surname != "b" {
+		return fmt.Errorf("get existing: %v,%v,%v", got, created, err)
+	}
+	if err := persons.Del(got); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+	got, created, err = persons.GetOrCreate("name", person{Name: "jan", Surname: "d"})
+	if err != nil || !created || got.UID() == p.UID() || got.Data().Surname != "d" {
+		return fmt.Errorf("create after delete: %v,%v,%v", got, created, err)
+	}
+
+	//concurrent calls with the same key add only one item
+	type result struct {
+		item    Item[person]
+		created bool
+		err     error
+	}
+	results := make(chan result)
+	for n := 0; n < 10; n++ {
+		go func(n int) {
+			item, created, err := persons.Upsert("name", person{Name: "piet", Surname: fmt.Sprintf("%d", n)})
+			results <- result{item, created, err}
+		}(n)
+	}
+	nrCreated := 0
+	uids := make(map[string]bool)
+	for n := 0; n < 10; n++ {
+		r := <-results
+		if r.err != nil {
+			return errors.Wrapf(r.err, "failed concurrent upsert")
+		}
+		if r.created {
+			nrCreated++
+		}
+		uids[r.item.UID()] = true
+	}
+	if nrCreated != 1 || len(uids) != 1 {
+		return fmt.Errorf("concurrent upserts created %d items with %d uids", nrCreated, len(uids))
+	}
+	for uid := range uids {
+		if revs, err := persons.History(uid); err != nil || len(revs) != 10 {
+			return fmt.Errorf("history after concurrent upserts: %d,%v", len(revs), err)
+		}
+	}
+	return nil
+} //upsertTest()
+
+//RunOptionTests tests the db options on new databases made by newDb
+func RunOptionTests(newDb func(options ...Option) (IDb, error)) error {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	//steps with milliseconds to check that all revisions keep them, also deleted revisions
+	step := time.Millisecond * 1500
+	db, err := newDb(WithClock(StepClock(start, step)), WithUIDs(SequentialUIDs("u-")))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create db")
+	}
+	persons, err := db.Table("clocked", person{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add table")
+	}
+	persons.PurgeAll()
+	p1, err := persons.AddItem(person{Name: "jan", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	p2, err := persons.AddItem(person{Name: "piet", Surname: "a"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to add")
+	}
+	if p1.UID() != "u-00000001" || p2.UID() != "u-00000002" {
+		return fmt.Errorf("uids %s,%s", p1.UID(), p2.UID())
+	}
+	p1, err = p1.Upd(person{Name: "jan", Surname: "b"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update")
+	}
+	if err := p1.Del(); err != nil {
+		return errors.Wrapf(err, "failed to delete")
+	}
+	revs, err := persons.History(p1.UID())
+	if err != nil || len(revs) != 3 {
+		return fmt.Errorf("history: %v,%v", revs, err)
+	}
+	for n, expected := range []time.Time{start, start.Add(step * 2), start.Add(step * 3)} {
+		if !revs[n].Rev().Timestamp().Equal(expected) {
+			return fmt.Errorf("rev[%d].ts=%v instead of %v", n, revs[n].Rev().Timestamp(), expected)
+		}
+	}
+
+	//ulids sort in the order they were generated, also within the same millisecond
+	for _, uids := range []UIDGenerator{ULIDs(nil), ULIDs(StepClock(start, 0))} {
+		last := ""
+		for n := 0; n < 100; n++ {
+			uid := uids()
+			if len(uid) != 26 || uid <= last {
+				return fmt.Errorf("ulid[%d]=%s after %s", n, uid, last)
+			}
+			last = uid
+		}
+	}
+	if uid := ULIDs(StepClock(start, 0))(); uid[:10] != "01DXJ3BK48" {
+		return fmt.Errorf("ulid %s does not start with the time", uid)
+	}
+	if uid := UUIDv4()(); len(uid) != 36 || uid[14] != '4' {
+		return fmt.Errorf("uuid v4 %s", uid)
+	}
+
+	//scheduled revisions become due and items expire when the clock of the db is moved
+	clock := &testClock{now: start}
+	if db, err = newDb(WithClock(clock.Now)); err != nil {
+		return errors.Wrapf(err, "failed to create db")
+	}
+	if err := scheduleTest(db, clock); err != nil {
+		return errors.Wrapf(err, "schedule test failed")
+	}
+	if err := ttlTest(db, clock); err != nil {
+		return errors.Wrapf(err, "ttl test failed")
+	}
+	return nil
+} //RunOptionTests()
+
+//testClock is a clock for tests that only moves when told to
+type testClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,86 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jansemmelink/log"
+)
+
+//ExpiredReason is the reason of deleted revisions written by ITable.Sweep(),
+//unless there is another reason in the context, see WithReason()
+const ExpiredReason = "expired"
+
+//TTL is the time-to-live of the items in a table
+//expired items are treated as deleted by GetItem(), index lookups, Items() and Count(),
+//until ITable.Sweep() writes their deleted revisions, or purges them
+//the zero value does not expire items, except those written with WithExpiry()
+type TTL struct {
+	//After is how long after its latest revision an item expires (0 for never)
+	After time.Duration
+	//Purge expired items when they are swept, instead of writing deleted revisions
+	Purge bool
+}
+
+//ExpiresAt returns when the item expires at its revision, zero if it does not expire
+//the expiry written with the revision (see WithExpiry()) takes precedence over the TTL
+func (ttl TTL) ExpiresAt(i IItem) time.Time {
+	if expires := i.Rev().Expires(); !expires.IsZero() {
+		return expires
+	}
+	if ttl.After > 0 {
+		return i.Rev().Timestamp().Add(ttl.After)
+	}
+	return time.Time{}
+}
+
+//Expired is true when the item expired at its revision before now
+//the clock is called only for items that expire
+func (ttl TTL) Expired(i IItem, now Clock) bool {
+	expires := ttl.ExpiresAt(i)
+	return !expires.IsZero() && !now().Before(expires)
+}
+
+func (t *table) SetTTL(ttl TTL) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.ttl = ttl
+}
+
+func (t *table) TTL() TTL {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.ttl
+}
+
+func (t *table) Sweep() (int, error) {
+	return t.SweepContext(context.Background())
+}
+
+func (t *table) SweepContext(ctx context.Context) (int, error) {
+	return 0, fmt.Errorf("db(%s).table(%s).Sweep() not implemented", t.db.Name(), t.name)
+}
+
+//StartSweeper sweeps the expired items from all tables in the db every interval until ctx is done
+func StartSweeper(ctx context.Context, db IDb, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for name, t := range db.Tables() {
+					n, err := t.SweepContext(ctx)
+					if err != nil {
+						log.Errorf("db(%s).table(%s) failed to sweep: %v", db.Name(), name, err)
+						continue
+					}
+					log.Debugf("db(%s).table(%s) swept %d items", db.Name(), name, n)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,399 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//Table of items with data of type T
+//it wraps an ITable, so it works with any backend, and does the type assertions
+//on item data that are otherwise needed, e.g. item.Data().(person)
+type Table[T IData] struct {
+	table ITable
+}
+
+//Item with data of type T
+//it embeds the IItem, with Data() returning T instead of IData
+type Item[T IData] struct {
+	IItem
+}
+
+//Index on a Table of items with data of type T
+type Index[T IData] struct {
+	index IIndex
+}
+
+//TableOf returns the named table of T in the db
+//the table is created if it does not exist yet, else it must store T
+func TableOf[T IData](db IDb, name string) (Table[T], error) {
+	return TableOfContext[T](context.Background(), db, name)
+}
+
+//TableOfContext is TableOf with a context
+func TableOfContext[T IData](ctx context.Context, db IDb, name string) (Table[T], error) {
+	var tmpl T
+	if t := db.GetTable(name); t != nil {
+		if t.Type() != reflect.TypeOf(tmpl) {
+			return Table[T]{}, fmt.Errorf("db(%s).table(%s) stores %v, not %T", db.Name(), name, t.Type(), tmpl)
+		}
+		return Table[T]{table: t}, nil
+	}
+	t, err := db.TableContext(ctx, name, tmpl)
+	if err != nil {
+		return Table[T]{}, err
+	}
+	return Table[T]{table: t}, nil
+}
+
+//Data of the item as T
+func (i Item[T]) Data() T {
+	return i.IItem.Data().(T)
+}
+
+//Table returns the untyped table
+func (t Table[T]) Table() ITable {
+	return t.table
+}
+
+//Name of the table
+func (t Table[T]) Name() string {
+	return t.table.Name()
+}
+
+//Add a new item
+func (t Table[T]) Add(data T) (Item[T], error) {
+	return t.AddContext(context.Background(), data)
+}
+
+//AddContext adds a new item
+func (t Table[T]) AddContext(ctx context.Context, data T) (Item[T], error) {
+	item, err := t.table.AddItemContext(ctx, data)
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//Get the latest revision of an item
+func (t Table[T]) Get(uid string) (Item[T], error) {
+	return t.GetContext(context.Background(), uid)
+}
+
+//GetContext gets the latest revision of an item
+func (t Table[T]) GetContext(ctx context.Context, uid string) (Item[T], error) {
+	item, err := t.table.GetItemContext(ctx, uid)
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//GetByNID gets the latest revision of the item with the nid
+func (t Table[T]) GetByNID(nid int) (Item[T], error) {
+	return t.GetByNIDContext(context.Background(), nid)
+}
+
+//GetByNIDContext gets the latest revision of the item with the nid
+func (t Table[T]) GetByNIDContext(ctx context.Context, nid int) (Item[T], error) {
+	item, err := t.table.GetItemByNIDContext(ctx, nid)
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//GetAsOf gets the revision of an item as known at knownAt and effective at validAt, see ITable.GetItemAsOf()
+func (t Table[T]) GetAsOf(uid string, knownAt time.Time, validAt time.Time) (Item[T], error) {
+	return t.GetAsOfContext(context.Background(), uid, knownAt, validAt)
+}
+
+//GetAsOfContext gets the revision of an item as known at knownAt and effective at validAt, see ITable.GetItemAsOf()
+func (t Table[T]) GetAsOfContext(ctx context.Context, uid string, knownAt time.Time, validAt time.Time) (Item[T], error) {
+	item, err := t.table.GetItemAsOfContext(ctx, uid, knownAt, validAt)
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//Upd writes data as the next revision of item
+func (t Table[T]) Upd(item Item[T], data T) (Item[T], error) {
+	return t.UpdContext(context.Background(), item, data)
+}
+
+//UpdContext writes data as the next revision of item
+func (t Table[T]) UpdContext(ctx context.Context, item Item[T], data T) (Item[T], error) {
+	if item.IItem == nil {
+		return Item[T]{}, fmt.Errorf("%s.Upd(nil)", t.Name())
+	}
+	if item.Table() != t.table {
+		return Item[T]{}, fmt.Errorf("%s.Upd(%s) from other table(%s)", t.Name(), item.UID(), item.Table().Name())
+	}
+	updItem, err := item.IItem.UpdContext(ctx, data)
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: updItem}, nil
+}
+
+//Upsert updates the item with the key of data in the named index, or adds data, see ITable.Upsert()
+func (t Table[T]) Upsert(indexName string, data T) (Item[T], bool, error) {
+	return t.UpsertContext(context.Background(), indexName, data)
+}
+
+//UpsertContext updates the item with the key of data in the named index, or adds data, see ITable.Upsert()
+func (t Table[T]) UpsertContext(ctx context.Context, indexName string, data T) (Item[T], bool, error) {
+	item, created, err := t.table.UpsertContext(ctx, indexName, data)
+	if err != nil {
+		return Item[T]{}, false, err
+	}
+	return Item[T]{IItem: item}, created, nil
+}
+
+//GetOrCreate gets the item with the key of data in the named index, or adds data, see ITable.GetOrCreate()
+func (t Table[T]) GetOrCreate(indexName string, data T) (Item[T], bool, error) {
+	return t.GetOrCreateContext(context.Background(), indexName, data)
+}
+
+//GetOrCreateContext gets the item with the key of data in the named index, or adds data, see ITable.GetOrCreate()
+func (t Table[T]) GetOrCreateContext(ctx context.Context, indexName string, data T) (Item[T], bool, error) {
+	item, created, err := t.table.GetOrCreateContext(ctx, indexName, data)
+	if err != nil {
+		return Item[T]{}, false, err
+	}
+	return Item[T]{IItem: item}, created, nil
+}
+
+//Schedule data as the next revision of item at a later time, see ITable.Schedule()
+func (t Table[T]) Schedule(item Item[T], data T, at time.Time) (ScheduledRev, error) {
+	return t.ScheduleContext(context.Background(), item, data, at)
+}
+
+//ScheduleContext schedules data as the next revision of item at a later time, see ITable.Schedule()
+func (t Table[T]) ScheduleContext(ctx context.Context, item Item[T], data T, at time.Time) (ScheduledRev, error) {
+	if item.IItem == nil {
+		return ScheduledRev{}, fmt.Errorf("%s.Schedule(nil)", t.Name())
+	}
+	if item.Table() != t.table {
+		return ScheduledRev{}, fmt.Errorf("%s.Schedule(%s) from other table(%s)", t.Name(), item.UID(), item.Table().Name())
+	}
+	return t.table.ScheduleContext(ctx, NextItem(item.IItem, data), at)
+}
+
+//Propose data as the next revision of item in a draft, see ITable.Propose()
+func (t Table[T]) Propose(item Item[T], data T) (Draft, error) {
+	return t.ProposeContext(context.Background(), item, data)
+}
+
+//ProposeContext proposes data as the next revision of item in a draft, see ITable.Propose()
+func (t Table[T]) ProposeContext(ctx context.Context, item Item[T], data T) (Draft, error) {
+	if item.IItem == nil {
+		return Draft{}, fmt.Errorf("%s.Propose(nil)", t.Name())
+	}
+	if item.Table() != t.table {
+		return Draft{}, fmt.Errorf("%s.Propose(%s) from other table(%s)", t.Name(), item.UID(), item.Table().Name())
+	}
+	return t.table.ProposeContext(ctx, NextItem(item.IItem, data))
+}
+
+//Approve a draft, writing it as the next revision, see ITable.Approve()
+func (t Table[T]) Approve(id int) (Item[T], error) {
+	return t.ApproveContext(context.Background(), id)
+}
+
+//ApproveContext approves a draft, writing it as the next revision, see ITable.Approve()
+func (t Table[T]) ApproveContext(ctx context.Context, id int) (Item[T], error) {
+	item, err := t.table.ApproveContext(ctx, id)
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//Modify gets, modifies and updates an item, see ITable.Modify()
+func (t Table[T]) Modify(uid string, fn func(current T) (T, error)) (Item[T], error) {
+	return t.ModifyContext(context.Background(), uid, fn)
+}
+
+//ModifyContext gets, modifies and updates an item, see ITable.Modify()
+func (t Table[T]) ModifyContext(ctx context.Context, uid string, fn func(current T) (T, error)) (Item[T], error) {
+	item, err := t.table.ModifyContext(ctx, uid, func(current IData) (IData, error) {
+		return fn(current.(T))
+	})
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//Restore a deleted item, see ITable.Restore()
+func (t Table[T]) Restore(uid string) (Item[T], error) {
+	return t.RestoreContext(context.Background(), uid)
+}
+
+//RestoreContext restores a deleted item, see ITable.Restore()
+func (t Table[T]) RestoreContext(ctx context.Context, uid string) (Item[T], error) {
+	item, err := t.table.RestoreContext(ctx, uid)
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//Revert an item to the data of an earlier revision, see ITable.Revert()
+func (t Table[T]) Revert(uid string, revNr int) (Item[T], error) {
+	return t.RevertContext(context.Background(), uid, revNr)
+}
+
+//RevertContext reverts an item to the data of an earlier revision, see ITable.Revert()
+func (t Table[T]) RevertContext(ctx context.Context, uid string, revNr int) (Item[T], error) {
+	item, err := t.table.RevertContext(ctx, uid, revNr)
+	if err != nil {
+		return Item[T]{}, err
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//Del deletes the item
+func (t Table[T]) Del(item Item[T]) error {
+	return t.DelContext(context.Background(), item)
+}
+
+//DelContext deletes the item
+func (t Table[T]) DelContext(ctx context.Context, item Item[T]) error {
+	if item.IItem == nil {
+		return fmt.Errorf("%s.Del(nil)", t.Name())
+	}
+	if item.Table() != t.table {
+		return fmt.Errorf("%s.Del(%s) from other table(%s)", t.Name(), item.UID(), item.Table().Name())
+	}
+	return item.IItem.DelContext(ctx)
+}
+
+//Items returns all items at their latest revision with uid as map index
+func (t Table[T]) Items() (map[string]Item[T], error) {
+	return t.ItemsContext(context.Background())
+}
+
+//ItemsContext returns all items at their latest revision with uid as map index
+func (t Table[T]) ItemsContext(ctx context.Context) (map[string]Item[T], error) {
+	list, err := t.table.ItemsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	typed := make(map[string]Item[T], len(list))
+	for uid, item := range list {
+		typed[uid] = Item[T]{IItem: item}
+	}
+	return typed, nil
+}
+
+//ItemsAsOf returns all items as known at knownAt and effective at validAt, see ITable.GetItemAsOf()
+func (t Table[T]) ItemsAsOf(knownAt time.Time, validAt time.Time) (map[string]Item[T], error) {
+	return t.ItemsAsOfContext(context.Background(), knownAt, validAt)
+}
+
+//ItemsAsOfContext returns all items as known at knownAt and effective at validAt, see ITable.GetItemAsOf()
+func (t Table[T]) ItemsAsOfContext(ctx context.Context, knownAt time.Time, validAt time.Time) (map[string]Item[T], error) {
+	list, err := t.table.ItemsAsOfContext(ctx, knownAt, validAt)
+	if err != nil {
+		return nil, err
+	}
+	typed := make(map[string]Item[T], len(list))
+	for uid, item := range list {
+		typed[uid] = Item[T]{IItem: item}
+	}
+	return typed, nil
+}
+
+//History returns all revisions of an item, oldest first
+func (t Table[T]) History(uid string) ([]Item[T], error) {
+	return t.HistoryContext(context.Background(), uid)
+}
+
+//HistoryContext returns all revisions of an item, oldest first
+func (t Table[T]) HistoryContext(ctx context.Context, uid string) ([]Item[T], error) {
+	list, err := t.table.HistoryContext(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return typedItems[T](list), nil
+}
+
+//Index defines a new index on the table
+func (t Table[T]) Index(name string, fields []string) (Index[T], error) {
+	index, err := t.table.Index(name, fields)
+	if err != nil {
+		return Index[T]{}, err
+	}
+	return Index[T]{index: index}, nil
+}
+
+//GetIndex returns an index previously defined on the table
+func (t Table[T]) GetIndex(name string) (Index[T], error) {
+	index := t.table.GetIndex(name)
+	if index == nil {
+		return Index[T]{}, fmt.Errorf("table %s does not have index %s", t.Name(), name)
+	}
+	return Index[T]{index: index}, nil
+}
+
+//Index returns the untyped index
+func (i Index[T]) Index() IIndex {
+	return i.index
+}
+
+//FindOne returns the item with the same values as key in the index fields
+//other fields of key are ignored, e.g. byName.FindOne(person{Name: "x"})
+//it fails with ErrNotFound when there is no such item
+func (i Index[T]) FindOne(key T) (Item[T], error) {
+	return i.FindOneContext(context.Background(), key)
+}
+
+//FindOneContext is FindOne with a context
+func (i Index[T]) FindOneContext(ctx context.Context, key T) (Item[T], error) {
+	item, err := i.index.FindOneContext(ctx, i.key(key))
+	if err != nil {
+		return Item[T]{}, err
+	}
+	if item == nil {
+		return Item[T]{}, errors.Wrapf(ErrNotFound, "%s.%s(%s)", i.index.Table().Name(), i.index.Name(), i.index.MapKey(i.key(key)).String())
+	}
+	return Item[T]{IItem: item}, nil
+}
+
+//Find returns all items with the same values as key in the index fields
+func (i Index[T]) Find(key T) ([]Item[T], error) {
+	return i.FindContext(context.Background(), key)
+}
+
+//FindContext is Find with a context
+func (i Index[T]) FindContext(ctx context.Context, key T) ([]Item[T], error) {
+	list, err := i.index.FindContext(ctx, i.key(key))
+	if err != nil {
+		return nil, err
+	}
+	return typedItems[T](list), nil
+}
+
+//key returns the index field values of data
+func (i Index[T]) key(data T) map[string]interface{} {
+	dataValue := reflect.ValueOf(data)
+	key := make(map[string]interface{})
+	for _, fieldName := range i.index.Fields() {
+		key[fieldName] = dataValue.FieldByName(fieldName).Interface()
+	}
+	return key
+}
+
+func typedItems[T IData](list []IItem) []Item[T] {
+	typed := make([]Item[T], len(list))
+	for n, item := range list {
+		typed[n] = Item[T]{IItem: item}
+	}
+	return typed
+}
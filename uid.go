@@ -0,0 +1,93 @@
+package items
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//UIDGenerator returns a new unique id for each item added to a table
+//it must be safe to call concurrently and ids must fit in 40 characters
+type UIDGenerator func() string
+
+//UUIDv4 generates random UUIDs, e.g. "0f8fad5b-d9cb-469f-a165-70867728950e"
+//it is the default generator of a database
+func UUIDv4() UIDGenerator {
+	return func() string {
+		return uuid.NewV4().String()
+	}
+}
+
+//ulidEncoding is the Crockford base32 alphabet used by ULIDs
+const ulidEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+//ULIDs generates ULIDs that sort in the order they were generated, e.g. "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+//each has the millisecond time from the clock (time.Now if nil) followed by 80 random bits,
+//which are incremented instead for ids generated in the same millisecond
+func ULIDs(clock Clock) UIDGenerator {
+	if clock == nil {
+		clock = time.Now
+	}
+	var mutex sync.Mutex
+	var lastMs uint64
+	var hi uint16 //top 16 of the 80 random bits
+	var lo uint64 //bottom 64 of the 80 random bits
+	return func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		ms := uint64(clock().UnixNano() / int64(time.Millisecond))
+		if ms <= lastMs {
+			//same millisecond (or clock went back): keep the time and increment the random part
+			ms = lastMs
+			lo++
+			if lo == 0 {
+				hi++
+				if hi == 0 {
+					ms++ //random part overflowed: move on to the next millisecond
+				}
+			}
+		} else {
+			var random [10]byte
+			if _, err := rand.Read(random[:]); err != nil {
+				panic(fmt.Sprintf("failed to read random bytes: %v", err))
+			}
+			hi = uint16(random[0])<<8 | uint16(random[1])
+			lo = 0
+			for _, b := range random[2:] {
+				lo = lo<<8 | uint64(b)
+			}
+		}
+		lastMs = ms
+
+		var id [26]byte
+		//48 bit time in the first 10 characters
+		for i := 9; i >= 0; i-- {
+			id[i] = ulidEncoding[ms&31]
+			ms >>= 5
+		}
+		//80 random bits in the last 16 characters
+		rhi, rlo := hi, lo
+		for i := 25; i >= 10; i-- {
+			id[i] = ulidEncoding[rlo&31]
+			rlo = rlo>>5 | uint64(rhi&31)<<59
+			rhi >>= 5
+		}
+		return string(id[:])
+	}
+} //ULIDs()
+
+//SequentialUIDs generates predictable ids for tests: prefix followed by 1, 2, 3, ...
+//zero padded so that they sort in the order they were generated, e.g. "u-00000001"
+func SequentialUIDs(prefix string) UIDGenerator {
+	var mutex sync.Mutex
+	n := 0
+	return func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		n++
+		return fmt.Sprintf("%s%08d", prefix, n)
+	}
+}
@@ -0,0 +1,96 @@
+package items
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (t *table) Upsert(indexName string, data IData) (IItem, bool, error) {
+	return t.UpsertContext(context.Background(), indexName, data)
+}
+
+func (t *table) UpsertContext(ctx context.Context, indexName string, data IData) (IItem, bool, error) {
+	return t.upsert(ctx, indexName, data, true)
+}
+
+func (t *table) GetOrCreate(indexName string, data IData) (IItem, bool, error) {
+	return t.GetOrCreateContext(context.Background(), indexName, data)
+}
+
+func (t *table) GetOrCreateContext(ctx context.Context, indexName string, data IData) (IItem, bool, error) {
+	return t.upsert(ctx, indexName, data, false)
+}
+
+//upsert finds the item with the key of data in the index and updates it (when update is true) or returns it,
+//else it adds data as a new item and returns true
+//the decision is checked again when the item is written, because the add fails with ErrDuplicateKey
+//when another item took the key, and the update fails with ErrRevisionConflict when the item was written,
+//in which case it finds the item again, according to the retry policy of the table
+func (t *table) upsert(ctx context.Context, indexName string, data IData, update bool) (IItem, bool, error) {
+	if data == nil {
+		return nil, false, fmt.Errorf("%s.Upsert(%s,nil)", t.name, indexName)
+	}
+	//use the table as registered in the db, which wraps this table
+	self := t.db.GetTable(t.name)
+	if self == nil {
+		return nil, false, fmt.Errorf("db(%s).table(%s) not found", t.db.Name(), t.name)
+	}
+	index := self.GetIndex(indexName)
+	if index == nil {
+		return nil, false, fmt.Errorf("table %s does not have index %s", t.name, indexName)
+	}
+	dataValue := reflect.ValueOf(data)
+	key := make(map[string]interface{})
+	for _, fieldName := range index.Fields() {
+		key[fieldName] = dataValue.FieldByName(fieldName).Interface()
+	}
+	retry := t.Retry()
+	if retry.Attempts <= 0 {
+		retry = DefaultRetry
+	}
+
+	backoff := retry.Backoff
+	var duplicate *ErrDuplicateKey
+	var conflict *ErrRevisionConflict
+	for attempt := 1; ; attempt++ {
+		cur, err := index.FindOneContext(ctx, key)
+		if err != nil {
+			return nil, false, err
+		}
+		switch {
+		case cur == nil:
+			var newItem IItem
+			if newItem, err = self.AddItemContext(ctx, data); err == nil {
+				return newItem, true, nil
+			}
+			if !errors.As(err, &duplicate) || duplicate.Index != indexName {
+				return nil, false, err
+			}
+		case !update:
+			return cur, false, nil
+		default:
+			var upd IItem
+			if upd, err = cur.UpdContext(ctx, data); err == nil {
+				return upd, false, nil
+			}
+			if !errors.As(err, &conflict) && !errors.Is(err, ErrDeleted) && !errors.Is(err, ErrNotFound) {
+				return nil, false, err
+			}
+		}
+		if attempt >= retry.Attempts {
+			return nil, false, &ErrRetriesExhausted{Table: t.name, UID: index.MapKey(key).String(), Attempts: attempt, Err: err}
+		}
+
+		//wait before finding the item again
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+} //table.upsert()